@@ -1,2 +1,342 @@
 package config
 
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/joho/godotenv"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds every setting the service needs. It is loaded once at startup
+// and passed explicitly to the parts of the application that need it.
+type Config struct {
+	Port                 string
+	JWTSecret            string
+	JWTKeysDir           string
+	JWTSigningKID        string
+	AccessTokenTTL       time.Duration
+	RefreshTokenTTL      time.Duration
+	PaymentWebhookSecret string
+	FakePaymentMode      string
+	AppURL               string
+	PasswordResetTTL     time.Duration
+	VerificationTTL      time.Duration
+	RequireVerifiedEmail bool
+	MFAIssuer            string
+	RequireAdminMFA      bool
+	Admin                AdminConfig
+	Login                LoginConfig
+	Mail                 MailConfig
+	Server               ServerConfig
+	DB                   DBConfig
+
+	// PaymentReconcileInterval is how often unsettled payments are retried.
+	PaymentReconcileInterval time.Duration
+}
+
+// AdminConfig seeds the first admin account on startup when no active admin
+// exists. Leave Email empty to skip seeding.
+type AdminConfig struct {
+	Name     string
+	Email    string
+	Password string
+}
+
+// LoginConfig controls brute-force protection. After the free attempts each
+// further failure doubles the lockout, starting at one second, up to MaxLockout.
+type LoginConfig struct {
+	AccountFreeAttempts int
+	IPFreeAttempts      int
+	MaxLockout          time.Duration
+}
+
+type MailConfig struct {
+	Driver string
+	Dir    string
+	From   string
+}
+
+type ServerConfig struct {
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+	// DrainDelay is how long the server keeps serving after readiness starts
+	// failing, so load balancers stop routing to it before listeners close.
+	DrainDelay time.Duration
+}
+
+type DBConfig struct {
+	Host            string
+	Port            string
+	User            string
+	Password        string
+	Name            string
+	SSLMode         string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+var validSSLModes = map[string]bool{
+	"disable": true, "allow": true, "prefer": true, "require": true, "verify-ca": true, "verify-full": true,
+}
+
+var validMailDrivers = map[string]bool{
+	"log": true, "file": true,
+}
+
+var validFakePaymentModes = map[string]bool{
+	"succeed": true, "decline": true, "timeout": true,
+}
+
+// Load builds the configuration from, in increasing order of precedence:
+// built-in defaults, an optional env file (-config, default .env), process
+// environment variables and command line flags. Every variable can be given
+// as a flag named after it, e.g. -db-host for DB_HOST. It returns the
+// arguments left after flag parsing, e.g. a subcommand.
+func Load(args []string) (*Config, []string, error) {
+	flags := flag.NewFlagSet("vigilant-spork", flag.ContinueOnError)
+	configFile := flags.String("config", ".env", "path to an optional env file")
+
+	// a first pass over the settings collects their names to register as flags
+	keys := map[string]string{}
+	dryRun := &loader{}
+	newConfig(dryRun)
+	for _, key := range dryRun.keys {
+		name := flagName(key)
+		keys[name] = key
+		flags.String(name, "", "overrides "+key)
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	explicitFile := false
+	flagValues := map[string]string{}
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			explicitFile = true
+			return
+		}
+		flagValues[keys[f.Name]] = f.Value.String()
+	})
+
+	fileValues, err := godotenv.Read(*configFile)
+	if err != nil {
+		if explicitFile || !errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, fmt.Errorf("reading config file %s: %w", *configFile, err)
+		}
+		fileValues = map[string]string{}
+	}
+
+	l := loader{flags: flagValues, file: fileValues}
+	cfg := newConfig(&l)
+
+	err = errors.Join(append(l.errs, cfg.Validate())...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, flags.Args(), nil
+}
+
+// newConfig reads every setting through l.
+func newConfig(l *loader) *Config {
+	return &Config{
+		Port:                 l.str("PORT", "8080"),
+		JWTSecret:            l.str("JWT_SECRET", ""),
+		JWTKeysDir:           l.str("JWT_KEYS_DIR", ""),
+		JWTSigningKID:        l.str("JWT_SIGNING_KID", ""),
+		AccessTokenTTL:       l.duration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:      l.duration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		PaymentWebhookSecret: l.str("PAYMENT_WEBHOOK_SECRET", ""),
+		FakePaymentMode:      l.str("FAKE_PAYMENT_MODE", "succeed"),
+		AppURL:               strings.TrimSuffix(l.str("APP_URL", "http://localhost:8080"), "/"),
+		PasswordResetTTL:     l.duration("PASSWORD_RESET_TTL", time.Hour),
+		VerificationTTL:      l.duration("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+		RequireVerifiedEmail: l.bool("REQUIRE_VERIFIED_EMAIL", false),
+		MFAIssuer:            l.str("MFA_ISSUER", "FutureMarket"),
+		RequireAdminMFA:      l.bool("REQUIRE_ADMIN_MFA", false),
+		Admin: AdminConfig{
+			Name:     l.str("ADMIN_NAME", "Administrator"),
+			Email:    l.str("ADMIN_EMAIL", ""),
+			Password: l.str("ADMIN_PASSWORD", ""),
+		},
+		Login: LoginConfig{
+			AccountFreeAttempts: l.int("LOGIN_ACCOUNT_FREE_ATTEMPTS", 5),
+			IPFreeAttempts:      l.int("LOGIN_IP_FREE_ATTEMPTS", 20),
+			MaxLockout:          l.duration("LOGIN_MAX_LOCKOUT", 15*time.Minute),
+		},
+		Mail: MailConfig{
+			Driver: l.str("MAIL_DRIVER", "log"),
+			Dir:    l.str("MAIL_DIR", "tmp/mail"),
+			From:   l.str("MAIL_FROM", "no-reply@futuremarket.local"),
+		},
+		Server: ServerConfig{
+			ReadTimeout:       l.duration("HTTP_READ_TIMEOUT", 15*time.Second),
+			ReadHeaderTimeout: l.duration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+			WriteTimeout:      l.duration("HTTP_WRITE_TIMEOUT", 30*time.Second),
+			IdleTimeout:       l.duration("HTTP_IDLE_TIMEOUT", 60*time.Second),
+			ShutdownTimeout:   l.duration("SHUTDOWN_TIMEOUT", 30*time.Second),
+			DrainDelay:        l.duration("SHUTDOWN_DRAIN_DELAY", 5*time.Second),
+		},
+		DB: DBConfig{
+			Host:            l.str("DB_HOST", "localhost"),
+			Port:            l.str("DB_PORT", "5432"),
+			User:            l.str("DB_USER", "postgres"),
+			Password:        l.str("DB_PASSWORD", ""),
+			Name:            l.str("DB_NAME", ""),
+			SSLMode:         l.str("DB_SSLMODE", "disable"),
+			MaxOpenConns:    l.int("DB_MAX_OPEN_CONNS", 25),
+			MaxIdleConns:    l.int("DB_MAX_IDLE_CONNS", 5),
+			ConnMaxLifetime: l.duration("DB_CONN_MAX_LIFETIME", 30*time.Minute),
+		},
+		PaymentReconcileInterval: l.duration("PAYMENT_RECONCILE_INTERVAL", time.Minute),
+	}
+}
+
+// flagName turns a variable name into its flag name: DB_HOST becomes db-host.
+func flagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.JWTKeysDir == "" && c.JWTSecret == "" {
+		errs = append(errs, errors.New("JWT_SECRET or JWT_KEYS_DIR must be set"))
+	}
+	if c.JWTKeysDir != "" && c.JWTSigningKID == "" {
+		errs = append(errs, errors.New("JWT_SIGNING_KID must be set when JWT_KEYS_DIR is used"))
+	}
+	if c.AccessTokenTTL <= 0 || c.RefreshTokenTTL <= 0 {
+		errs = append(errs, errors.New("ACCESS_TOKEN_TTL and REFRESH_TOKEN_TTL must be positive"))
+	} else if c.RefreshTokenTTL <= c.AccessTokenTTL {
+		errs = append(errs, errors.New("REFRESH_TOKEN_TTL must be longer than ACCESS_TOKEN_TTL"))
+	}
+	if n, err := strconv.Atoi(c.Port); err != nil || n < 1 || n > 65535 {
+		errs = append(errs, fmt.Errorf("PORT %q is not a valid port", c.Port))
+	}
+	if !validFakePaymentModes[c.FakePaymentMode] {
+		errs = append(errs, fmt.Errorf("FAKE_PAYMENT_MODE %q must be succeed, decline or timeout", c.FakePaymentMode))
+	}
+	if c.PaymentReconcileInterval <= 0 {
+		errs = append(errs, errors.New("PAYMENT_RECONCILE_INTERVAL must be positive"))
+	}
+
+	if c.PasswordResetTTL <= 0 || c.VerificationTTL <= 0 {
+		errs = append(errs, errors.New("PASSWORD_RESET_TTL and EMAIL_VERIFICATION_TTL must be positive"))
+	}
+	if c.Login.AccountFreeAttempts < 0 || c.Login.IPFreeAttempts < 0 || c.Login.MaxLockout <= 0 {
+		errs = append(errs, errors.New("login attempt limits cannot be negative and LOGIN_MAX_LOCKOUT must be positive"))
+	}
+	if !validMailDrivers[c.Mail.Driver] {
+		errs = append(errs, fmt.Errorf("MAIL_DRIVER %q must be log or file", c.Mail.Driver))
+	}
+	if c.Admin.Email != "" && len(c.Admin.Password) < 8 {
+		errs = append(errs, errors.New("ADMIN_PASSWORD must be at least 8 characters when ADMIN_EMAIL is set"))
+	}
+
+	if c.Server.ReadTimeout <= 0 || c.Server.ReadHeaderTimeout <= 0 || c.Server.WriteTimeout <= 0 ||
+		c.Server.IdleTimeout <= 0 || c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("server timeouts must be positive"))
+	}
+	if c.Server.DrainDelay < 0 {
+		errs = append(errs, errors.New("SHUTDOWN_DRAIN_DELAY cannot be negative"))
+	}
+
+	if c.DB.Host == "" {
+		errs = append(errs, errors.New("DB_HOST must be set"))
+	}
+	if c.DB.User == "" {
+		errs = append(errs, errors.New("DB_USER must be set"))
+	}
+	if c.DB.Name == "" {
+		errs = append(errs, errors.New("DB_NAME must be set"))
+	}
+	if n, err := strconv.Atoi(c.DB.Port); err != nil || n < 1 || n > 65535 {
+		errs = append(errs, fmt.Errorf("DB_PORT %q is not a valid port", c.DB.Port))
+	}
+	if !validSSLModes[c.DB.SSLMode] {
+		errs = append(errs, fmt.Errorf("DB_SSLMODE %q is not a valid sslmode", c.DB.SSLMode))
+	}
+	if c.DB.MaxOpenConns < 0 || c.DB.MaxIdleConns < 0 || c.DB.ConnMaxLifetime < 0 {
+		errs = append(errs, errors.New("database pool settings cannot be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// DSN returns the connection string for the Postgres driver.
+func (c DBConfig) DSN() string {
+	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
+		c.Host, c.User, c.Password, c.Name, c.Port, c.SSLMode)
+}
+
+type loader struct {
+	flags map[string]string
+	file  map[string]string
+	errs  []error
+	keys  []string
+}
+
+func (l *loader) str(key, def string) string {
+	l.keys = append(l.keys, key)
+	if v, ok := l.flags[key]; ok {
+		return v
+	}
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	if v, ok := l.file[key]; ok {
+		return v
+	}
+	return def
+}
+
+func (l *loader) int(key string, def int) int {
+	raw := l.str(key, "")
+	if raw == "" {
+		return def
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s %q is not an integer", key, raw))
+		return def
+	}
+	return n
+}
+
+func (l *loader) bool(key string, def bool) bool {
+	raw := l.str(key, "")
+	if raw == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s %q is not a boolean", key, raw))
+		return def
+	}
+	return b
+}
+
+func (l *loader) duration(key string, def time.Duration) time.Duration {
+	raw := l.str(key, "")
+	if raw == "" {
+		return def
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s %q is not a duration", key, raw))
+		return def
+	}
+	return d
+}
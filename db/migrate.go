@@ -0,0 +1,297 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"gorm.io/gorm"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey is the pg_advisory_lock key held while migrating, so that
+// only one instance applies migrations at a time.
+const migrationLockKey = 7243981562
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationState struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+// LoadMigrations reads the embedded migrations/NNNN_name.{up,down}.sql files,
+// ordered by version. Every migration must have both halves.
+func LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		fileName := entry.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(fileName, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %q", fileName)
+		}
+
+		versionStr, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name %q", fileName)
+		}
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %q: %w", fileName, err)
+		}
+
+		contents, err := migrationFiles.ReadFile("migrations/" + fileName)
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s is missing its up or down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// MigrateUp applies every migration that has not been applied yet and returns
+// how many were applied.
+func MigrateUp(ctx context.Context, gdb *gorm.DB) (int, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	err = withMigrationLock(ctx, gdb, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if _, ok := done[m.Version]; ok {
+				continue
+			}
+			err = runMigration(ctx, conn, m.Up,
+				`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, now())`, m.Version, m.Name)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", m.Version, m.Name, err)
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateDown reverts the most recent steps applied migrations and returns how
+// many were reverted.
+func MigrateDown(ctx context.Context, gdb *gorm.DB, steps int) (int, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	reverted := 0
+	err = withMigrationLock(ctx, gdb, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && reverted < steps; i-- {
+			m := migrations[i]
+			if _, ok := done[m.Version]; !ok {
+				continue
+			}
+			err = runMigration(ctx, conn, m.Down,
+				`DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", m.Version, m.Name, err)
+			}
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// MigrationStatus lists every known migration along with when it was applied.
+// AppliedAt is nil for pending migrations. It only reads, so unlike MigrateUp
+// and MigrateDown it does not wait for the migration lock; while a migration
+// is running it reports the state as of the last committed one.
+func MigrationStatus(ctx context.Context, gdb *gorm.DB) ([]MigrationState, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := gdb.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// a database that was never migrated has no bookkeeping table yet
+	var tracked bool
+	err = conn.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&tracked)
+	if err != nil {
+		return nil, err
+	}
+	done := map[int64]time.Time{}
+	if tracked {
+		done, err = appliedVersions(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var states []MigrationState
+	for _, m := range migrations {
+		state := MigrationState{Version: m.Version, Name: m.Name}
+		if appliedAt, ok := done[m.Version]; ok {
+			state.AppliedAt = &appliedAt
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// PendingMigrations reports how many known migrations have not been applied.
+// Like MigrationStatus it does not take the migration lock, so it is cheap
+// enough for readiness probes.
+func PendingMigrations(ctx context.Context, gdb *gorm.DB) (int, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	var versions []int64
+	err = gdb.WithContext(ctx).Table("schema_migrations").Pluck("version", &versions).Error
+	if err != nil {
+		return 0, err
+	}
+
+	done := map[int64]bool{}
+	for _, v := range versions {
+		done[v] = true
+	}
+
+	pending := 0
+	for _, m := range migrations {
+		if !done[m.Version] {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+func withMigrationLock(ctx context.Context, gdb *gorm.DB, fn func(conn *sql.Conn) error) error {
+	sqlDB, err := gdb.DB()
+	if err != nil {
+		return err
+	}
+
+	// advisory locks belong to a session, so everything runs on one connection
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey)
+	if err != nil {
+		return fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamptz NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		done[version] = appliedAt
+	}
+	return done, rows.Err()
+}
+
+func runMigration(ctx context.Context, conn *sql.Conn, script string, bookkeeping string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, script)
+	if err == nil {
+		_, err = tx.ExecContext(ctx, bookkeeping, args...)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,146 @@
+-- Baseline: the schema previously produced by GORM AutoMigrate. Tables are
+-- created with IF NOT EXISTS so databases that were auto-migrated adopt it,
+-- and columns added to those tables since are added explicitly, because an
+-- existing table skips its CREATE TABLE entirely.
+
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+CREATE TABLE IF NOT EXISTS users (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name text,
+    email text CONSTRAINT uni_users_email UNIQUE,
+    password text,
+    role text,
+    cart_id uuid,
+    order_id uuid,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS blacklisted_tokens (
+    token text PRIMARY KEY,
+    created_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS products (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name text,
+    description text,
+    category text,
+    price bigint,
+    stock_quantity bigint,
+    rating bigint,
+    review_count bigint,
+    data text,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS carts (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id uuid CONSTRAINT fk_carts_user REFERENCES users (id),
+    total bigint
+);
+
+CREATE TABLE IF NOT EXISTS cart_items (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    cart_id uuid CONSTRAINT fk_carts_items REFERENCES carts (id),
+    product_id uuid CONSTRAINT fk_cart_items_product REFERENCES products (id),
+    quantity bigint,
+    unit_price bigint,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id uuid CONSTRAINT fk_orders_user REFERENCES users (id),
+    total bigint,
+    status text,
+    restocked_at timestamptz,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS restocked_at timestamptz;
+
+CREATE TABLE IF NOT EXISTS order_items (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    order_id uuid,
+    product_id uuid,
+    product_name text,
+    product_category text,
+    quantity bigint,
+    unit_price bigint,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+ALTER TABLE order_items
+    ADD COLUMN IF NOT EXISTS product_name text,
+    ADD COLUMN IF NOT EXISTS product_category text;
+
+CREATE TABLE IF NOT EXISTS reviews (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    title text,
+    description text,
+    rating bigint,
+    product_id uuid CONSTRAINT fk_products_reviews REFERENCES products (id),
+    user_id uuid CONSTRAINT fk_reviews_user REFERENCES users (id),
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS order_status_histories (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    order_id uuid,
+    from_status text,
+    to_status text,
+    changed_by uuid,
+    note text,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_order_status_histories_order_id ON order_status_histories (order_id);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key text,
+    user_id uuid,
+    method text,
+    path text,
+    request_hash text,
+    completed boolean,
+    status_code bigint,
+    content_type text,
+    response_body bytea,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (key, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS payments (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    order_id uuid CONSTRAINT fk_payments_order REFERENCES orders (id),
+    provider text,
+    provider_ref text,
+    amount bigint,
+    status text,
+    failure_reason text,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_payments_order_id ON payments (order_id);
+CREATE INDEX IF NOT EXISTS idx_payments_provider_ref ON payments (provider_ref);
+
+CREATE TABLE IF NOT EXISTS payment_events (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    provider text,
+    event_id text,
+    type text,
+    payment_id uuid,
+    created_at timestamptz
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payment_events_provider_event ON payment_events (provider, event_id);
+
+-- orders placed before the state machine existed
+UPDATE orders SET status = 'PLACED' WHERE status = 'ORDER PLACED';
@@ -0,0 +1,23 @@
+CREATE TABLE sessions (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL REFERENCES users (id),
+    user_agent text,
+    ip_address text,
+    expires_at timestamptz NOT NULL,
+    last_used_at timestamptz NOT NULL,
+    revoked_at timestamptz,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
+
+CREATE TABLE refresh_tokens (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    session_id uuid NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+    token_hash text NOT NULL,
+    used_at timestamptz,
+    expires_at timestamptz NOT NULL,
+    created_at timestamptz
+);
+CREATE INDEX idx_refresh_tokens_session_id ON refresh_tokens (session_id);
+CREATE UNIQUE INDEX idx_refresh_tokens_token_hash ON refresh_tokens (token_hash);
@@ -0,0 +1,8 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at timestamptz;
+
+-- Unknown roles fall back to customer. Existing admins are kept: registration
+-- used to accept any role, so some of them may have granted it to themselves,
+-- but SQL cannot tell them apart from the operators. They have to be audited
+-- by hand after upgrading (see "Upgrading from self-registered roles" in the
+-- README).
+UPDATE users SET role = 'customer' WHERE role IS NULL OR role NOT IN ('admin', 'customer');
@@ -0,0 +1,4 @@
+ALTER TABLE users DROP CONSTRAINT IF EXISTS fk_users_role;
+UPDATE users SET role = 'customer' WHERE role NOT IN ('admin', 'customer');
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,29 @@
+CREATE TABLE roles (
+    name text PRIMARY KEY,
+    description text,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+
+CREATE TABLE role_permissions (
+    role text NOT NULL REFERENCES roles (name) ON DELETE CASCADE ON UPDATE CASCADE,
+    permission text NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+
+INSERT INTO roles (name, description, created_at, updated_at) VALUES
+    ('admin', 'Full access', now(), now()),
+    ('customer', 'Shops and reviews products', now(), now()),
+    ('support', 'Moderates reviews and manages customer accounts', now(), now()),
+    ('warehouse', 'Fulfils orders', now(), now());
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'catalog:write'),
+    ('admin', 'orders:fulfil'),
+    ('admin', 'reviews:moderate'),
+    ('admin', 'users:manage'),
+    ('support', 'reviews:moderate'),
+    ('support', 'users:manage'),
+    ('warehouse', 'orders:fulfil');
+
+ALTER TABLE users ADD CONSTRAINT fk_users_role FOREIGN KEY (role) REFERENCES roles (name) ON UPDATE CASCADE;
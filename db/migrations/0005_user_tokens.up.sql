@@ -0,0 +1,11 @@
+CREATE TABLE user_tokens (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    purpose text NOT NULL,
+    token_hash text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    used_at timestamptz,
+    created_at timestamptz
+);
+CREATE INDEX idx_user_tokens_user_id ON user_tokens (user_id);
+CREATE UNIQUE INDEX idx_user_tokens_token_hash ON user_tokens (token_hash);
@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at timestamptz;
+
+-- accounts created before verification existed keep working
+UPDATE users SET email_verified_at = now() WHERE email_verified_at IS NULL;
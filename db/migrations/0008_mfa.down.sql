@@ -0,0 +1,3 @@
+ALTER TABLE sessions DROP COLUMN IF EXISTS mfa_verified;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS user_mfa;
@@ -0,0 +1,19 @@
+CREATE TABLE user_mfa (
+    user_id uuid PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret text NOT NULL,
+    confirmed_at timestamptz,
+    last_counter bigint NOT NULL DEFAULT 0,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+
+CREATE TABLE recovery_codes (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash text NOT NULL,
+    used_at timestamptz,
+    created_at timestamptz
+);
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes (user_id);
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS mfa_verified boolean NOT NULL DEFAULT false;
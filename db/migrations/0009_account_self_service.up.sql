@@ -0,0 +1 @@
+ALTER TABLE user_tokens ADD COLUMN IF NOT EXISTS payload text NOT NULL DEFAULT '';
@@ -0,0 +1,38 @@
+CREATE TABLE addresses (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    label text NOT NULL DEFAULT '',
+    full_name text NOT NULL,
+    line1 text NOT NULL,
+    line2 text NOT NULL DEFAULT '',
+    city text NOT NULL,
+    region text NOT NULL DEFAULT '',
+    postal_code text NOT NULL,
+    country text NOT NULL,
+    phone text NOT NULL DEFAULT '',
+    is_default boolean NOT NULL DEFAULT false,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+CREATE INDEX idx_addresses_user_id ON addresses (user_id);
+CREATE UNIQUE INDEX idx_addresses_one_default ON addresses (user_id) WHERE is_default;
+
+-- Orders placed before this migration have no address; the snapshot columns
+-- stay empty for them.
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS shipping_full_name text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS shipping_line1 text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS shipping_line2 text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS shipping_city text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS shipping_region text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS shipping_postal_code text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS shipping_country text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS shipping_phone text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS billing_full_name text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS billing_line1 text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS billing_line2 text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS billing_city text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS billing_region text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS billing_postal_code text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS billing_country text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS billing_phone text NOT NULL DEFAULT '';
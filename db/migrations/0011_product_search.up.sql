@@ -0,0 +1,10 @@
+-- Full-text search over products. Name matches (weight A) rank above
+-- description matches (weight B). The column is generated, so it never goes
+-- stale and the application never writes it.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(description, '')), 'B')
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING GIN (search_vector);
@@ -0,0 +1,17 @@
+DROP INDEX IF EXISTS idx_products_name_id;
+DROP INDEX IF EXISTS idx_products_review_count_id;
+DROP INDEX IF EXISTS idx_products_rating_id;
+DROP INDEX IF EXISTS idx_products_price_id;
+DROP INDEX IF EXISTS idx_products_created_at_id;
+
+ALTER TABLE products
+    ALTER COLUMN name DROP NOT NULL,
+    ALTER COLUMN name DROP DEFAULT,
+    ALTER COLUMN price DROP NOT NULL,
+    ALTER COLUMN price DROP DEFAULT,
+    ALTER COLUMN rating DROP NOT NULL,
+    ALTER COLUMN rating DROP DEFAULT,
+    ALTER COLUMN review_count DROP NOT NULL,
+    ALTER COLUMN review_count DROP DEFAULT,
+    ALTER COLUMN created_at DROP NOT NULL,
+    ALTER COLUMN created_at DROP DEFAULT;
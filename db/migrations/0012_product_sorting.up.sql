@@ -0,0 +1,25 @@
+-- Keyset pagination compares (sort column, id) tuples, which only works when
+-- the sort columns are never NULL.
+UPDATE products SET name = '' WHERE name IS NULL;
+UPDATE products SET price = 0 WHERE price IS NULL;
+UPDATE products SET rating = 0 WHERE rating IS NULL;
+UPDATE products SET review_count = 0 WHERE review_count IS NULL;
+UPDATE products SET created_at = now() WHERE created_at IS NULL;
+
+ALTER TABLE products
+    ALTER COLUMN name SET DEFAULT '',
+    ALTER COLUMN name SET NOT NULL,
+    ALTER COLUMN price SET DEFAULT 0,
+    ALTER COLUMN price SET NOT NULL,
+    ALTER COLUMN rating SET DEFAULT 0,
+    ALTER COLUMN rating SET NOT NULL,
+    ALTER COLUMN review_count SET DEFAULT 0,
+    ALTER COLUMN review_count SET NOT NULL,
+    ALTER COLUMN created_at SET DEFAULT now(),
+    ALTER COLUMN created_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_products_created_at_id ON products (created_at, id);
+CREATE INDEX IF NOT EXISTS idx_products_price_id ON products (price, id);
+CREATE INDEX IF NOT EXISTS idx_products_rating_id ON products (rating, id);
+CREATE INDEX IF NOT EXISTS idx_products_review_count_id ON products (review_count, id);
+CREATE INDEX IF NOT EXISTS idx_products_name_id ON products (name, id);
@@ -0,0 +1,8 @@
+ALTER TABLE products ADD COLUMN category text;
+
+UPDATE products p SET category = c.name
+FROM categories c
+WHERE c.id = p.category_id;
+
+ALTER TABLE products DROP COLUMN category_id;
+DROP TABLE IF EXISTS categories;
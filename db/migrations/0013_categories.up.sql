@@ -0,0 +1,33 @@
+CREATE TABLE categories (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    parent_id uuid REFERENCES categories (id),
+    name text NOT NULL,
+    slug text NOT NULL CONSTRAINT uni_categories_slug UNIQUE,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+CREATE INDEX idx_categories_parent_id ON categories (parent_id);
+
+-- Turn the free-text categories into root categories. Spellings that only
+-- differ in case or punctuation share a slug and become one category. The
+-- slug rule matches utils.Slugify, including its fallback for names without
+-- ASCII letters or digits, which keeps such categories apart.
+INSERT INTO categories (name, slug, created_at, updated_at)
+SELECT MIN(trim(category)),
+       COALESCE(NULLIF(trim(BOTH '-' FROM regexp_replace(lower(trim(category)), '[^a-z0-9]+', '-', 'g')), ''),
+                'category-' || left(md5(trim(category)), 8)),
+       now(), now()
+FROM products
+WHERE trim(COALESCE(category, '')) <> ''
+GROUP BY 2;
+
+ALTER TABLE products ADD COLUMN category_id uuid CONSTRAINT fk_products_category REFERENCES categories (id);
+CREATE INDEX idx_products_category_id ON products (category_id);
+
+UPDATE products p SET category_id = c.id
+FROM categories c
+WHERE trim(COALESCE(p.category, '')) <> ''
+  AND c.slug = COALESCE(NULLIF(trim(BOTH '-' FROM regexp_replace(lower(trim(p.category)), '[^a-z0-9]+', '-', 'g')), ''),
+                         'category-' || left(md5(trim(p.category)), 8));
+
+ALTER TABLE products DROP COLUMN category;
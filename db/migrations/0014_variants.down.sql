@@ -0,0 +1,10 @@
+ALTER TABLE order_items
+    DROP COLUMN IF EXISTS variant_id,
+    DROP COLUMN IF EXISTS sku,
+    DROP COLUMN IF EXISTS variant_label;
+
+ALTER TABLE cart_items DROP COLUMN IF EXISTS variant_id;
+
+DROP TABLE IF EXISTS variant_options;
+DROP TABLE IF EXISTS variants;
+DROP TABLE IF EXISTS product_options;
@@ -0,0 +1,46 @@
+CREATE TABLE product_options (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id uuid NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    name text NOT NULL,
+    position bigint NOT NULL DEFAULT 0,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+CREATE UNIQUE INDEX idx_product_options_product_name ON product_options (product_id, lower(name));
+
+CREATE TABLE variants (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id uuid NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    sku text NOT NULL CONSTRAINT uni_variants_sku UNIQUE,
+    price bigint,
+    stock_quantity bigint NOT NULL DEFAULT 0 CHECK (stock_quantity >= 0),
+    created_at timestamptz,
+    updated_at timestamptz
+);
+CREATE INDEX idx_variants_product_id ON variants (product_id);
+
+CREATE TABLE variant_options (
+    variant_id uuid NOT NULL REFERENCES variants (id) ON DELETE CASCADE,
+    option_id uuid NOT NULL REFERENCES product_options (id) ON DELETE CASCADE,
+    value text NOT NULL,
+    PRIMARY KEY (variant_id, option_id)
+);
+
+-- Every existing product becomes a single default variant holding its stock.
+INSERT INTO variants (product_id, sku, stock_quantity, created_at, updated_at)
+SELECT id, 'P-' || replace(id::text, '-', ''), GREATEST(COALESCE(stock_quantity, 0), 0), now(), now()
+FROM products;
+UPDATE products p SET stock_quantity = v.stock_quantity FROM variants v WHERE v.product_id = p.id;
+
+ALTER TABLE cart_items ADD COLUMN variant_id uuid CONSTRAINT fk_cart_items_variant REFERENCES variants (id) ON DELETE CASCADE;
+UPDATE cart_items ci SET variant_id = v.id FROM variants v WHERE v.product_id = ci.product_id;
+DELETE FROM cart_items WHERE variant_id IS NULL;
+ALTER TABLE cart_items ALTER COLUMN variant_id SET NOT NULL;
+
+-- Order items keep no foreign key: like product_id, variant_id is part of a
+-- snapshot that must outlive the variant.
+ALTER TABLE order_items
+    ADD COLUMN variant_id uuid,
+    ADD COLUMN sku text NOT NULL DEFAULT '',
+    ADD COLUMN variant_label text NOT NULL DEFAULT '';
+UPDATE order_items oi SET variant_id = v.id, sku = v.sku FROM variants v WHERE v.product_id = oi.product_id;
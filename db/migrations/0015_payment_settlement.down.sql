@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_payments_unsettled;
+ALTER TABLE payments DROP COLUMN IF EXISTS pending_status;
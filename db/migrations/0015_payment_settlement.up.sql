@@ -0,0 +1,7 @@
+-- The order status a payment is being settled for. It is set before the
+-- provider is asked to capture, void or refund and cleared once the order has
+-- moved, so an interrupted settlement can be finished by the reconciler.
+ALTER TABLE payments ADD COLUMN pending_status text NOT NULL DEFAULT '';
+
+CREATE INDEX idx_payments_unsettled ON payments (updated_at)
+    WHERE status = 'PENDING' OR pending_status <> '';
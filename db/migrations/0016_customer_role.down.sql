@@ -0,0 +1,2 @@
+-- the removed permissions were never meant to be there; nothing to restore
+SELECT 1;
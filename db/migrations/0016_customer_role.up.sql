@@ -0,0 +1,3 @@
+-- customer is now built in: every self-registered account gets it, so it
+-- must not grant anything
+DELETE FROM role_permissions WHERE role = 'customer';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_products_price_from_id;
+ALTER TABLE products DROP COLUMN IF EXISTS price_from;
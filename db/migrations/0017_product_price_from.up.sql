@@ -0,0 +1,10 @@
+-- price_from is the lowest price any of a product's variants sells at, kept
+-- on the product like stock_quantity so listings can sort, filter and page
+-- by it.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS price_from bigint NOT NULL DEFAULT 0;
+
+UPDATE products SET price_from = COALESCE(
+    (SELECT MIN(COALESCE(v.price, products.price)) FROM variants v WHERE v.product_id = products.id),
+    products.price);
+
+CREATE INDEX IF NOT EXISTS idx_products_price_from_id ON products (price_from, id);
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"net/http"
+	"vigilant-spork/middleware"
+	"vigilant-spork/models"
+	"vigilant-spork/services"
+)
+
+// AddressHandler serves the address book under /me/addresses.
+type AddressHandler struct {
+	Service *services.AddressService
+}
+
+type AddressRequest struct {
+	Label      string `json:"label"`
+	FullName   string `json:"full_name"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+	Phone      string `json:"phone"`
+	IsDefault  bool   `json:"is_default"`
+}
+
+func (req *AddressRequest) toAddress() *models.Address {
+	return &models.Address{
+		Label:      req.Label,
+		FullName:   req.FullName,
+		Line1:      req.Line1,
+		Line2:      req.Line2,
+		City:       req.City,
+		Region:     req.Region,
+		PostalCode: req.PostalCode,
+		Country:    req.Country,
+		Phone:      req.Phone,
+		IsDefault:  req.IsDefault,
+	}
+}
+
+func (h *AddressHandler) ListAddresses(w http.ResponseWriter, r *http.Request) {
+	addresses, err := h.Service.ListAddresses(r.Context(), middleware.GetUserID(r.Context()))
+	if err != nil {
+		http.Error(w, "unable to fetch addresses", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(addresses)
+}
+
+func (h *AddressHandler) GetAddress(w http.ResponseWriter, r *http.Request) {
+	addressID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid address ID", http.StatusBadRequest)
+		return
+	}
+
+	address, err := h.Service.GetAddress(r.Context(), middleware.GetUserID(r.Context()), addressID)
+	if err != nil {
+		writeAddressError(w, err, "unable to fetch address")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(address)
+}
+
+func (h *AddressHandler) CreateAddress(w http.ResponseWriter, r *http.Request) {
+	var req AddressRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	address := req.toAddress()
+	err = h.Service.CreateAddress(r.Context(), middleware.GetUserID(r.Context()), address)
+	if err != nil {
+		writeAddressError(w, err, "unable to save address")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(address)
+}
+
+func (h *AddressHandler) UpdateAddress(w http.ResponseWriter, r *http.Request) {
+	addressID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid address ID", http.StatusBadRequest)
+		return
+	}
+
+	var req AddressRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	address, err := h.Service.UpdateAddress(r.Context(), middleware.GetUserID(r.Context()), addressID, req.toAddress())
+	if err != nil {
+		writeAddressError(w, err, "unable to update address")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(address)
+}
+
+func (h *AddressHandler) SetDefaultAddress(w http.ResponseWriter, r *http.Request) {
+	addressID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid address ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Service.SetDefaultAddress(r.Context(), middleware.GetUserID(r.Context()), addressID)
+	if err != nil {
+		writeAddressError(w, err, "unable to update default address")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("default address updated"))
+}
+
+func (h *AddressHandler) DeleteAddress(w http.ResponseWriter, r *http.Request) {
+	addressID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid address ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Service.DeleteAddress(r.Context(), middleware.GetUserID(r.Context()), addressID)
+	if err != nil {
+		writeAddressError(w, err, "unable to delete address")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeAddressError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrAddressNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrInvalidAddress):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrTooManyAddresses):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/gofrs/uuid"
 	"github.com/gorilla/mux"
 	"gorm.io/gorm"
+	"io"
 	"net/http"
 	"vigilant-spork/middleware"
 	"vigilant-spork/repository"
@@ -19,11 +20,20 @@ type CartHandler struct {
 
 type CartItemResponse struct {
 	ProductID uuid.UUID `json:"product_id"`
+	VariantID uuid.UUID `json:"variant_id"`
 	Name      string    `json:"name"`
+	SKU       string    `json:"sku"`
+	Variant   string    `json:"variant,omitempty"`
 	Quantity  int       `json:"quantity"`
 	UnitPrice string    `json:"unit_price"`
 }
 
+// AddToCartRequest is the optional body of an add to cart. VariantID may be
+// left out for a product with a single variant.
+type AddToCartRequest struct {
+	VariantID *uuid.UUID `json:"variant_id"`
+}
+
 func (h *CartHandler) AddToCart(w http.ResponseWriter, r *http.Request) {
 	productID := mux.Vars(r)["product_id"]
 	productUUID, err := uuid.FromString(productID)
@@ -38,11 +48,22 @@ func (h *CartHandler) AddToCart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.Service.AddToCart(userID, productUUID)
+	var req AddToCartRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Service.AddToCart(r.Context(), userID, productUUID, req.VariantID)
 	if err != nil {
 		switch {
 		case errors.Is(err, repository.ErrInsufficientStock):
 			http.Error(w, "Insufficient stock", http.StatusConflict)
+		case errors.Is(err, services.ErrProductNotFound), errors.Is(err, services.ErrVariantNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, services.ErrVariantRequired):
+			http.Error(w, err.Error(), http.StatusBadRequest)
 		case errors.Is(err, gorm.ErrRecordNotFound):
 			http.Error(w, "Cart not found", http.StatusNotFound)
 		default:
@@ -62,7 +83,7 @@ func (h *CartHandler) ViewCart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cart, err := h.Service.ViewCart(userID)
+	cart, err := h.Service.ViewCart(r.Context(), userID)
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -79,7 +100,10 @@ func (h *CartHandler) ViewCart(w http.ResponseWriter, r *http.Request) {
 	for _, item := range cart.Items {
 		itemsResp = append(itemsResp, CartItemResponse{
 			ProductID: item.ProductID,
+			VariantID: item.VariantID,
 			Name:      item.Product.Name,
+			SKU:       item.Variant.SKU,
+			Variant:   item.Product.VariantLabel(&item.Variant),
 			Quantity:  item.Quantity,
 			UnitPrice: fmt.Sprintf("%.2f", float64(item.UnitPrice)/100),
 		})
@@ -97,7 +121,8 @@ func (h *CartHandler) ViewCart(w http.ResponseWriter, r *http.Request) {
 
 func (h *CartHandler) UpdateItemQuantity(w http.ResponseWriter, r *http.Request) {
 	type UpdateQuantity struct {
-		Quantity int `json:"quantity"`
+		VariantID *uuid.UUID `json:"variant_id"`
+		Quantity  int        `json:"quantity"`
 	}
 
 	var updatedQuantity UpdateQuantity
@@ -122,11 +147,14 @@ func (h *CartHandler) UpdateItemQuantity(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	cartItem, err := h.Service.UpdateItemQuantity(userID, productUUID, quantity)
+	cartItem, err := h.Service.UpdateItemQuantity(r.Context(), userID, productUUID, updatedQuantity.VariantID, quantity)
 	if err != nil {
 		switch {
-		case errors.Is(err, gorm.ErrRecordNotFound):
+		case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, services.ErrProductNotFound),
+			errors.Is(err, services.ErrVariantNotFound):
 			http.Error(w, "item not found", http.StatusNotFound)
+		case errors.Is(err, services.ErrVariantRequired):
+			http.Error(w, err.Error(), http.StatusBadRequest)
 		case errors.Is(err, repository.ErrInsufficientStock):
 			http.Error(w, "insufficient stock", http.StatusBadRequest)
 		case errors.Is(err, repository.ErrInvalidQuantity):
@@ -139,7 +167,10 @@ func (h *CartHandler) UpdateItemQuantity(w http.ResponseWriter, r *http.Request)
 
 	response := CartItemResponse{
 		ProductID: cartItem.ProductID,
+		VariantID: cartItem.VariantID,
 		Name:      cartItem.Product.Name,
+		SKU:       cartItem.Variant.SKU,
+		Variant:   cartItem.Product.VariantLabel(&cartItem.Variant),
 		Quantity:  cartItem.Quantity,
 		UnitPrice: fmt.Sprintf("%.2f", float64(cartItem.UnitPrice)/100),
 	}
@@ -163,11 +194,26 @@ func (h *CartHandler) RemoveItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.Service.RemoveItem(userID, productUUID)
-	if errors.Is(err, gorm.ErrRecordNotFound) {
+	var variantID *uuid.UUID
+	if raw := r.URL.Query().Get("variant_id"); raw != "" {
+		id, err := uuid.FromString(raw)
+		if err != nil {
+			http.Error(w, "invalid variant id", http.StatusBadRequest)
+			return
+		}
+		variantID = &id
+	}
+
+	err = h.Service.RemoveItem(r.Context(), userID, productUUID, variantID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, services.ErrProductNotFound) ||
+		errors.Is(err, services.ErrVariantNotFound) {
 		http.Error(w, "item not found in cart", http.StatusNotFound)
 		return
 	}
+	if errors.Is(err, services.ErrVariantRequired) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"net/http"
+	"vigilant-spork/services"
+)
+
+type CategoryHandler struct {
+	Service *services.CategoryService
+}
+
+// CategoryRequest creates or replaces a category. A missing slug is derived
+// from the name; a null parent_id makes it a root category.
+type CategoryRequest struct {
+	Name     string     `json:"name"`
+	Slug     string     `json:"slug"`
+	ParentID *uuid.UUID `json:"parent_id"`
+}
+
+func (h *CategoryHandler) GetCategories(w http.ResponseWriter, r *http.Request) {
+	tree, err := h.Service.CategoryTree(r.Context())
+	if err != nil {
+		http.Error(w, "unable to fetch categories", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tree)
+}
+
+func (h *CategoryHandler) CreateCategory(w http.ResponseWriter, r *http.Request) {
+	var req CategoryRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	category, err := h.Service.CreateCategory(r.Context(), req.Name, req.Slug, req.ParentID)
+	if err != nil {
+		writeCategoryError(w, err, "unable to create category")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(category)
+}
+
+func (h *CategoryHandler) UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	categoryID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	var req CategoryRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	category, err := h.Service.UpdateCategory(r.Context(), categoryID, req.Name, req.Slug, req.ParentID)
+	if err != nil {
+		writeCategoryError(w, err, "unable to update category")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(category)
+}
+
+func (h *CategoryHandler) DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	categoryID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Service.DeleteCategory(r.Context(), categoryID)
+	if err != nil {
+		writeCategoryError(w, err, "unable to delete category")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeCategoryError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrCategoryNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrInvalidCategory), errors.Is(err, services.ErrInvalidSlug),
+		errors.Is(err, services.ErrUnknownParent), errors.Is(err, services.ErrCategoryCycle):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrSlugTaken), errors.Is(err, services.ErrCategoryNotEmpty):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+}
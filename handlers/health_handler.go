@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"net/http"
+	"sync/atomic"
+	"time"
+	"vigilant-spork/db"
+	"vigilant-spork/utils"
+)
+
+type HealthHandler struct {
+	Db       *gorm.DB
+	draining atomic.Bool
+}
+
+// SetDraining makes the readiness probe fail so the orchestrator stops
+// sending traffic while in-flight requests finish.
+func (h *HealthHandler) SetDraining() {
+	h.draining.Store(true)
+}
+
+func (h *HealthHandler) Liveness(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (h *HealthHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	checks := map[string]string{
+		"database":   "ok",
+		"migrations": "ok",
+	}
+	ready := true
+
+	if h.draining.Load() {
+		checks["server"] = "shutting down"
+		ready = false
+	}
+
+	sqlDB, err := h.Db.DB()
+	if err == nil {
+		err = sqlDB.PingContext(ctx)
+	}
+	if err != nil {
+		checks["database"] = "unreachable"
+		ready = false
+	}
+
+	pending, err := db.PendingMigrations(ctx, h.Db)
+	switch {
+	case err != nil:
+		checks["migrations"] = "unknown"
+		ready = false
+	case pending > 0:
+		checks["migrations"] = fmt.Sprintf("%d pending", pending)
+		ready = false
+	}
+
+	if !ready {
+		utils.WriteJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "unavailable", "checks": checks})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"status": "ok", "checks": checks})
+}
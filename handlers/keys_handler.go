@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"net/http"
+	"vigilant-spork/middleware"
+	"vigilant-spork/utils"
+)
+
+type KeysHandler struct {
+	Keys *middleware.KeySet
+}
+
+// JWKS publishes the public verification keys so other services can validate
+// access tokens without sharing a secret.
+func (h *KeysHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.WriteJSON(w, http.StatusOK, h.Keys.JWKS())
+}
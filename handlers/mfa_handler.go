@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"vigilant-spork/middleware"
+	"vigilant-spork/services"
+)
+
+type MFAHandler struct {
+	Service *services.MFAService
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func (h *MFAHandler) Enroll(w http.ResponseWriter, r *http.Request) {
+	enrollment, err := h.Service.Enroll(r.Context(), middleware.GetUserID(r.Context()))
+	if err != nil {
+		writeMFAError(w, err, "unable to start enrolment")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+func (h *MFAHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req MFACodeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Code == "" {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.Service.Confirm(ctx, middleware.GetUserID(ctx), middleware.GetSessionID(ctx), req.Code)
+	if err != nil {
+		writeMFAError(w, err, "unable to enable two-factor authentication")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (h *MFAHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	var req MFACodeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Code == "" {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Service.Disable(r.Context(), middleware.GetUserID(r.Context()), req.Code)
+	if err != nil {
+		writeMFAError(w, err, "unable to disable two-factor authentication")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *MFAHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var req MFACodeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Code == "" {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.Service.RegenerateRecoveryCodes(r.Context(), middleware.GetUserID(r.Context()), req.Code)
+	if err != nil {
+		writeMFAError(w, err, "unable to generate recovery codes")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func writeMFAError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidMFACode):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, services.ErrMFAAlreadyEnabled), errors.Is(err, services.ErrMFANotEnrolled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrMFARequired):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+}
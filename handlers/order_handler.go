@@ -4,11 +4,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"vigilant-spork/middleware"
+	"vigilant-spork/models"
 	"vigilant-spork/repository"
 	"vigilant-spork/services"
 	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
 	"gorm.io/gorm"
 )
 
@@ -23,6 +27,50 @@ type OrderResponse struct {
 	CreatedAt string    `json:"created_at"`
 }
 
+type OrderItemResponse struct {
+	ProductID uuid.UUID `json:"product_id"`
+	Name      string    `json:"name"`
+	Category  string    `json:"category"`
+	SKU       string    `json:"sku"`
+	Variant   string    `json:"variant,omitempty"`
+	Quantity  int       `json:"quantity"`
+	UnitPrice string    `json:"unit_price"`
+	Subtotal  string    `json:"subtotal"`
+}
+
+type OrderDetailResponse struct {
+	OrderResponse
+	ShippingAddress models.OrderAddress `json:"shipping_address"`
+	BillingAddress  models.OrderAddress `json:"billing_address"`
+	Items           []OrderItemResponse `json:"items"`
+}
+
+// CheckoutRequest picks the order's addresses, either from the address book
+// by ID or inline. An empty body ships to and bills the default address.
+type CheckoutRequest struct {
+	ShippingAddressID *uuid.UUID      `json:"shipping_address_id"`
+	ShippingAddress   *AddressRequest `json:"shipping_address"`
+	BillingAddressID  *uuid.UUID      `json:"billing_address_id"`
+	BillingAddress    *AddressRequest `json:"billing_address"`
+}
+
+func (req *CheckoutRequest) toAddresses() services.CheckoutAddresses {
+	var addresses services.CheckoutAddresses
+	if req.ShippingAddressID != nil {
+		addresses.ShippingAddressID = *req.ShippingAddressID
+	}
+	if req.ShippingAddress != nil {
+		addresses.Shipping = req.ShippingAddress.toAddress()
+	}
+	if req.BillingAddressID != nil {
+		addresses.BillingAddressID = *req.BillingAddressID
+	}
+	if req.BillingAddress != nil {
+		addresses.Billing = req.BillingAddress.toAddress()
+	}
+	return addresses
+}
+
 func (h *OrderHandler) MoveCartToOrder(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	userID := middleware.GetUserID(ctx)
@@ -31,19 +79,38 @@ func (h *OrderHandler) MoveCartToOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.Service.MoveCartToOrder(ctx, userID)
+	var req CheckoutRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	order, err := h.Service.MoveCartToOrder(ctx, userID, req.toAddresses())
 	if err != nil {
 		switch {
+		case errors.Is(err, services.ErrAddressNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, services.ErrInvalidAddress), errors.Is(err, services.ErrAddressRequired):
+			http.Error(w, err.Error(), http.StatusBadRequest)
 		case errors.Is(err, repository.ErrInsufficientStock):
 			http.Error(w, "Insufficient stock", http.StatusConflict)
 		case errors.Is(err, gorm.ErrRecordNotFound):
 			http.Error(w, "Cart not found", http.StatusNotFound)
+		case errors.Is(err, services.ErrPaymentDeclined):
+			http.Error(w, "Payment declined", http.StatusPaymentRequired)
 		default:
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 		return
 	}
 
+	if order.Status == models.OrderStatusPlaced {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("order placed, payment pending"))
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("order created successfully"))
 }
@@ -55,7 +122,7 @@ func (h *OrderHandler) GetOrderHistory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	orders, err := h.Service.GetOrderHistory(userID)
+	orders, err := h.Service.GetOrderHistory(r.Context(), userID)
 	if err != nil {
 		http.Error(w, "Unable to fetch order history", http.StatusInternalServerError)
 		return
@@ -76,3 +143,148 @@ func (h *OrderHandler) GetOrderHistory(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
+
+func (h *OrderHandler) GetOrder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := middleware.GetUserID(ctx)
+	if userID == uuid.Nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	orderID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	order, items, err := h.Service.GetOrder(ctx, userID, orderID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "order not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Unable to fetch order", http.StatusInternalServerError)
+		return
+	}
+
+	response := OrderDetailResponse{
+		OrderResponse: OrderResponse{
+			ID:        order.ID,
+			Total:     fmt.Sprintf("%.2f", float64(order.Total)/100),
+			Status:    order.Status,
+			CreatedAt: order.CreatedAt.Format("2006-01-02 15:04:05"),
+		},
+		ShippingAddress: order.ShippingAddress,
+		BillingAddress:  order.BillingAddress,
+		Items:           []OrderItemResponse{},
+	}
+	for _, item := range items {
+		response.Items = append(response.Items, OrderItemResponse{
+			ProductID: item.ProductID,
+			Name:      item.ProductName,
+			Category:  item.ProductCategory,
+			SKU:       item.SKU,
+			Variant:   item.VariantLabel,
+			Quantity:  item.Quantity,
+			UnitPrice: fmt.Sprintf("%.2f", float64(item.UnitPrice)/100),
+			Subtotal:  fmt.Sprintf("%.2f", float64(item.UnitPrice*int64(item.Quantity))/100),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+type TransitionRequest struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+type StatusHistoryResponse struct {
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	ChangedBy  uuid.UUID `json:"changed_by"`
+	Note       string    `json:"note"`
+	CreatedAt  string    `json:"created_at"`
+}
+
+func (h *OrderHandler) TransitionOrder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	orderID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	var req TransitionRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	order, err := h.Service.TransitionOrder(ctx, orderID, strings.ToUpper(req.Status), middleware.GetUserID(ctx), req.Note)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			http.Error(w, "order not found", http.StatusNotFound)
+		case errors.Is(err, services.ErrUnknownOrderStatus):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, services.ErrInvalidTransition), errors.Is(err, repository.ErrStatusConflict),
+			errors.Is(err, services.ErrPaymentNotAuthorized), errors.Is(err, services.ErrPaymentInProgress):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, services.ErrPaymentFailed):
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		default:
+			http.Error(w, "unable to update order status", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	response := OrderResponse{
+		ID:        order.ID,
+		Total:     fmt.Sprintf("%.2f", float64(order.Total)/100),
+		Status:    order.Status,
+		CreatedAt: order.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *OrderHandler) GetStatusHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	orderID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	history, err := h.Service.GetStatusHistory(ctx, orderID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "order not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "unable to fetch order history", http.StatusInternalServerError)
+		return
+	}
+
+	response := []StatusHistoryResponse{}
+	for _, entry := range history {
+		response = append(response, StatusHistoryResponse{
+			FromStatus: entry.FromStatus,
+			ToStatus:   entry.ToStatus,
+			ChangedBy:  entry.ChangedBy,
+			Note:       entry.Note,
+			CreatedAt:  entry.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -2,14 +2,15 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gofrs/uuid"
 	"github.com/gorilla/mux"
 	"math"
 	"net/http"
 	"strconv"
-	"vigilant-spork/middleware"
 	"vigilant-spork/models"
+	"vigilant-spork/repository"
 	"vigilant-spork/services"
 )
 
@@ -21,6 +22,7 @@ type GetProductResponse struct {
 	Name        string          `json:"name"`
 	Description string          `json:"description"`
 	Price       string          `json:"price"`
+	PriceFrom   string          `json:"price_from"`
 	Stock       int             `json:"stock"`
 	Data        string          `json:"data"`
 	Rating      int             `json:"rating"`
@@ -28,14 +30,16 @@ type GetProductResponse struct {
 }
 
 type GetProductByIDResponse struct {
-	Name          string           `json:"name"`
-	Description   string           `json:"description"`
-	Price         string           `json:"price"`
-	Stock         int              `json:"stock"`
-	Data          string           `json:"data"`
-	Rating        int              `json:"rating"`
-	Reviews       []ReviewResponse `json:"reviews"`
-	ReviewMessage string           `json:"review_message,omitempty"`
+	Name          string            `json:"name"`
+	Description   string            `json:"description"`
+	Price         string            `json:"price"`
+	Stock         int               `json:"stock"`
+	Data          string            `json:"data"`
+	Rating        int               `json:"rating"`
+	Reviews       []ReviewResponse  `json:"reviews"`
+	ReviewMessage string            `json:"review_message,omitempty"`
+	Options       []string          `json:"options"`
+	Variants      []VariantResponse `json:"variants"`
 }
 
 func (h *ProductHandler) AddProduct(w http.ResponseWriter, r *http.Request) {
@@ -46,13 +50,7 @@ func (h *ProductHandler) AddProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	role := middleware.GetUserRole(r.Context())
-	if role != "admin" {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-
-	err = h.Service.AddProduct(products)
+	err = h.Service.AddProduct(r.Context(), products)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -71,7 +69,7 @@ func (h *ProductHandler) GetProductByID(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	product, err := h.Service.GetProductByID(productUUID)
+	product, err := h.Service.GetProductByID(r.Context(), productUUID)
 	if err != nil {
 		http.Error(w, "Product not found", http.StatusNotFound)
 		return
@@ -95,6 +93,16 @@ func (h *ProductHandler) GetProductByID(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
+	options := []string{}
+	for _, o := range product.Options {
+		options = append(options, o.Name)
+	}
+
+	variants := []VariantResponse{}
+	for i := range product.Variants {
+		variants = append(variants, variantResponse(product, &product.Variants[i]))
+	}
+
 	response := GetProductByIDResponse{
 		Name:          product.Name,
 		Description:   product.Description,
@@ -104,6 +112,8 @@ func (h *ProductHandler) GetProductByID(w http.ResponseWriter, r *http.Request)
 		Rating:        int(product.Rating),
 		Reviews:       reviews,
 		ReviewMessage: reviewMessage,
+		Options:       options,
+		Variants:      variants,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -111,6 +121,9 @@ func (h *ProductHandler) GetProductByID(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(response)
 }
 
+// maxProductsLimit caps the page size of a product listing.
+const maxProductsLimit = 100
+
 func (h *ProductHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
 	page, err := strconv.Atoi(r.URL.Query().Get("page"))
 	if err != nil || page < 1 {
@@ -121,6 +134,9 @@ func (h *ProductHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
 	if err != nil || limit < 1 {
 		limit = 20
 	}
+	if limit > maxProductsLimit {
+		limit = maxProductsLimit
+	}
 
 	minPrice, err := strconv.Atoi(r.URL.Query().Get("min_price"))
 	if err != nil || minPrice < 1 {
@@ -137,33 +153,64 @@ func (h *ProductHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	category := r.URL.Query().Get("category")
+	minRating, err := strconv.Atoi(r.URL.Query().Get("min_rating"))
+	if err != nil || minRating < 0 || minRating > 5 {
+		minRating = 0
+	}
 
-	totalItems, err := h.Service.GetTotalItems()
+	filter := repository.ProductFilter{
+		MinPrice:  minPrice,
+		MaxPrice:  maxPrice,
+		Category:  r.URL.Query().Get("category"),
+		Query:     r.URL.Query().Get("q"),
+		MinRating: minRating,
+		InStock:   r.URL.Query().Get("in_stock") == "true",
+	}
+
+	totalItems, err := h.Service.GetTotalItems(r.Context(), filter)
 	if err != nil {
 		http.Error(w, "unable to get total number of items", http.StatusInternalServerError)
 		return
 	}
 
-	totalPages := (int(totalItems) + limit - 1) / limit
+	// a cursor picks the page by itself, so page numbers only apply without one
+	cursor := r.URL.Query().Get("cursor")
+	var totalPages, currentPage *int
+	if cursor == "" {
+		pages := (int(totalItems) + limit - 1) / limit
 
-	if totalPages == 0 {
-		totalPages = 1
-	}
+		if pages == 0 {
+			pages = 1
+		}
 
-	if page > totalPages {
-		page = totalPages
+		if page > pages {
+			page = pages
+		}
+		totalPages, currentPage = &pages, &page
 	}
 
-	rawData, err := h.Service.GetProducts(page, limit, minPrice, maxPrice, category)
+	rawData, nextCursor, err := h.Service.GetProducts(r.Context(), page, limit, filter,
+		r.URL.Query().Get("sort"), cursor)
 	if err != nil {
+		if errors.Is(err, repository.ErrInvalidSort) || errors.Is(err, repository.ErrInvalidCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "unable to get products", http.StatusInternalServerError)
+		return
+	}
+
+	facets, err := h.Service.GetFacets(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "unable to get facets", http.StatusInternalServerError)
+		return
 	}
 
 	type Metadata struct {
-		TotalItems  int64 `json:"total_items"`
-		TotalPages  int   `json:"total_pages"`
-		CurrentPage int   `json:"current_page"`
+		TotalItems  int64  `json:"total_items"`
+		TotalPages  *int   `json:"total_pages,omitempty"`
+		CurrentPage *int   `json:"current_page,omitempty"`
+		NextCursor  string `json:"next_cursor,omitempty"`
 	}
 
 	var refinedData []GetProductResponse
@@ -172,6 +219,7 @@ func (h *ProductHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
 			Name:        p.Name,
 			Description: p.Description,
 			Price:       fmt.Sprintf("%.2f", float64(p.Price)/100),
+			PriceFrom:   fmt.Sprintf("%.2f", float64(p.PriceFrom)/100),
 			Stock:       p.StockQuantity,
 			Data:        p.Data,
 			Rating:      int(p.Rating),
@@ -180,6 +228,7 @@ func (h *ProductHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
 	type Response struct {
 		Products []GetProductResponse
 		Metadata Metadata
+		Facets   *repository.ProductFacets
 	}
 
 	response := Response{
@@ -187,8 +236,10 @@ func (h *ProductHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
 		Metadata: Metadata{
 			TotalItems:  totalItems,
 			TotalPages:  totalPages,
-			CurrentPage: page,
+			CurrentPage: currentPage,
+			NextCursor:  nextCursor,
 		},
+		Facets: facets,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -196,6 +247,23 @@ func (h *ProductHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+func (h *ProductHandler) SuggestProducts(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 || limit > 20 {
+		limit = 8
+	}
+
+	suggestions, err := h.Service.SuggestProducts(r.Context(), r.URL.Query().Get("q"), limit)
+	if err != nil {
+		http.Error(w, "unable to get suggestions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(suggestions)
+}
+
 func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	var product models.Product
 	err := json.NewDecoder(r.Body).Decode(&product)
@@ -204,12 +272,6 @@ func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	role := middleware.GetUserRole(r.Context())
-	if role != "admin" {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-
 	productID := mux.Vars(r)["id"]
 	productUUID, err := uuid.FromString(productID)
 	if err != nil {
@@ -217,7 +279,11 @@ func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updatedProduct, err := h.Service.UpdateProduct(productUUID, &product)
+	updatedProduct, err := h.Service.UpdateProduct(r.Context(), productUUID, &product)
+	if errors.Is(err, services.ErrUnknownCategory) || errors.Is(err, services.ErrStockByVariant) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "unable to update product", http.StatusInternalServerError)
 		return
@@ -227,6 +293,7 @@ func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		Name:        updatedProduct.Name,
 		Description: updatedProduct.Description,
 		Price:       fmt.Sprintf("%.2f", float64(updatedProduct.Price)/100),
+		PriceFrom:   fmt.Sprintf("%.2f", float64(updatedProduct.PriceFrom)/100),
 		Stock:       updatedProduct.StockQuantity,
 		Data:        updatedProduct.Data,
 	}
@@ -237,12 +304,6 @@ func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *ProductHandler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
-	role := middleware.GetUserRole(r.Context())
-	if role != "admin" {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-
 	productID := mux.Vars(r)["id"]
 	productUUID, err := uuid.FromString(productID)
 	if err != nil {
@@ -250,7 +311,7 @@ func (h *ProductHandler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.Service.DeleteProduct(productUUID)
+	err = h.Service.DeleteProduct(r.Context(), productUUID)
 	if err != nil {
 		http.Error(w, "unable to delete product", http.StatusInternalServerError)
 		return
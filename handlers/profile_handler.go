@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"vigilant-spork/middleware"
+	"vigilant-spork/services"
+	"vigilant-spork/utils"
+)
+
+// ProfileHandler serves the /me endpoints, where users manage their own
+// account.
+type ProfileHandler struct {
+	Service *services.UserService
+}
+
+type ProfileResponse struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	Role          string `json:"role"`
+	EmailVerified bool   `json:"email_verified"`
+	MFAEnabled    bool   `json:"mfa_enabled"`
+	CreatedAt     string `json:"created_at"`
+}
+
+// ReauthRequest is embedded in requests for sensitive changes. Code is only
+// needed when MFA is enabled.
+type ReauthRequest struct {
+	CurrentPassword string `json:"current_password"`
+	Code            string `json:"code"`
+}
+
+func toProfileResponse(profile *services.Profile) ProfileResponse {
+	return ProfileResponse{
+		ID:            profile.User.ID.String(),
+		Name:          profile.User.Name,
+		Email:         profile.User.Email,
+		Role:          profile.User.Role,
+		EmailVerified: profile.User.EmailVerifiedAt != nil,
+		MFAEnabled:    profile.MFAEnabled,
+		CreatedAt:     profile.User.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
+
+func (h *ProfileHandler) GetMe(w http.ResponseWriter, r *http.Request) {
+	profile, err := h.Service.GetProfile(r.Context(), middleware.GetUserID(r.Context()))
+	if err != nil {
+		http.Error(w, "unable to load profile", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(toProfileResponse(profile))
+}
+
+func (h *ProfileHandler) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	profile, err := h.Service.UpdateName(r.Context(), middleware.GetUserID(r.Context()), req.Name)
+	if err != nil {
+		writeProfileError(w, err, "unable to update profile")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(toProfileResponse(profile))
+}
+
+func (h *ProfileHandler) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+		ReauthRequest
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Service.RequestEmailChange(r.Context(), middleware.GetUserID(r.Context()), req.Email,
+		req.CurrentPassword, req.Code, utils.ClientIP(r))
+	if err != nil {
+		writeProfileError(w, err, "unable to change email")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("a confirmation link has been sent to the new address"))
+}
+
+func (h *ProfileHandler) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "missing token", http.StatusBadRequest)
+		return
+	}
+
+	err := h.Service.ConfirmEmailChange(r.Context(), token)
+	if err != nil {
+		writeProfileError(w, err, "unable to change email")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("email address changed"))
+}
+
+func (h *ProfileHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req struct {
+		NewPassword string `json:"new_password"`
+		ReauthRequest
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Service.ChangePassword(ctx, middleware.GetUserID(ctx), middleware.GetSessionID(ctx),
+		req.CurrentPassword, req.NewPassword, req.Code, utils.ClientIP(r))
+	if err != nil {
+		writeProfileError(w, err, "unable to change password")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("password changed, other sessions have been logged out"))
+}
+
+func (h *ProfileHandler) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	var req ReauthRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Service.DeleteAccount(r.Context(), middleware.GetUserID(r.Context()), req.CurrentPassword, req.Code, utils.ClientIP(r))
+	if err != nil {
+		writeProfileError(w, err, "unable to delete account")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeProfileError(w http.ResponseWriter, err error, fallback string) {
+	var locked *services.LockedOutError
+	switch {
+	case errors.As(err, &locked):
+		writeLoginError(w, err)
+	case errors.Is(err, services.ErrReauthFailed):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, services.ErrMFACodeRequired), errors.Is(err, services.ErrInvalidName),
+		errors.Is(err, services.ErrWeakPassword), errors.Is(err, services.ErrEmailUnchanged),
+		errors.Is(err, services.ErrInvalidEmailToken), errors.Is(err, services.ErrInvalidEmail):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrEmailExists), errors.Is(err, services.ErrLastAdmin):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+}
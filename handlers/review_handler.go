@@ -40,7 +40,7 @@ func (h *ReviewHandler) SubmitReview(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
 	review.UserID = userID
 
-	if err := h.Service.SubmitReview(&review); err != nil {
+	if err := h.Service.SubmitReview(r.Context(), &review); err != nil {
 		switch err {
 		case services.ErrInvalidRating:
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -64,7 +64,7 @@ func (h *ReviewHandler) GetReviews(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	reviews, err := h.Service.GetReviewsForProduct(productID)
+	reviews, err := h.Service.GetReviewsForProduct(r.Context(), productID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -105,7 +105,7 @@ func (h *ReviewHandler) UpdateReview(w http.ResponseWriter, r *http.Request) {
 
 	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
 
-	existing, err := h.Service.GetReviewByUserForProduct(userID, productID)
+	existing, err := h.Service.GetReviewByUserForProduct(r.Context(), userID, productID)
 	if err != nil {
 		http.Error(w, "review not found", http.StatusNotFound)
 		return
@@ -115,7 +115,7 @@ func (h *ReviewHandler) UpdateReview(w http.ResponseWriter, r *http.Request) {
 	existing.Description = update.Description
 	existing.Rating = update.Rating
 
-	if err := h.Service.UpdateReview(existing); err != nil {
+	if err := h.Service.UpdateReview(r.Context(), existing); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -140,13 +140,28 @@ func (h *ReviewHandler) DeleteReview(w http.ResponseWriter, r *http.Request) {
 
 	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
 
-	existing, err := h.Service.GetReviewByUserForProduct(userID, productID)
-	if err != nil {
-		http.Error(w, "review not found", http.StatusNotFound)
-		return
+	var existing *models.Review
+	if middleware.HasPermission(r.Context(), models.PermissionReviewsModerate) {
+		// moderators remove the review named in the path, whoever wrote it
+		reviewID, err := uuid.FromString(vars["review_id"])
+		if err != nil {
+			http.Error(w, "invalid review ID", http.StatusBadRequest)
+			return
+		}
+		existing, err = h.Service.GetReviewByID(r.Context(), reviewID)
+		if err != nil || existing.ProductID != productID {
+			http.Error(w, "review not found", http.StatusNotFound)
+			return
+		}
+	} else {
+		existing, err = h.Service.GetReviewByUserForProduct(r.Context(), userID, productID)
+		if err != nil {
+			http.Error(w, "review not found", http.StatusNotFound)
+			return
+		}
 	}
 
-	if err := h.Service.DeleteReview(existing.ID); err != nil {
+	if err := h.Service.DeleteReview(r.Context(), existing.ID); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
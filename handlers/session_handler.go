@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"net/http"
+	"vigilant-spork/middleware"
+	"vigilant-spork/services"
+)
+
+type SessionHandler struct {
+	Service *services.SessionService
+}
+
+type SessionResponse struct {
+	ID         uuid.UUID `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  string    `json:"created_at"`
+	LastUsedAt string    `json:"last_used_at"`
+	Current    bool      `json:"current"`
+}
+
+func (h *SessionHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.RefreshToken == "" {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.Service.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidRefreshToken), errors.Is(err, services.ErrRefreshTokenReused):
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case errors.Is(err, services.ErrAccountDisabled):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, "unable to refresh token", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tokens)
+}
+
+func (h *SessionHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := middleware.GetUserID(ctx)
+	if userID == uuid.Nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := h.Service.ListSessions(ctx, userID)
+	if err != nil {
+		http.Error(w, "unable to fetch sessions", http.StatusInternalServerError)
+		return
+	}
+
+	currentID := middleware.GetSessionID(ctx)
+	response := []SessionResponse{}
+	for _, s := range sessions {
+		response = append(response, SessionResponse{
+			ID:         s.ID,
+			UserAgent:  s.UserAgent,
+			IPAddress:  s.IPAddress,
+			CreatedAt:  s.CreatedAt.Format("2006-01-02 15:04:05"),
+			LastUsedAt: s.LastUsedAt.Format("2006-01-02 15:04:05"),
+			Current:    s.ID == currentID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *SessionHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := middleware.GetUserID(ctx)
+	if userID == uuid.Nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sessionID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Service.RevokeSession(ctx, userID, sessionID)
+	if err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "unable to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *SessionHandler) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := middleware.GetUserID(ctx)
+	if userID == uuid.Nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err := h.Service.RevokeAllSessions(ctx, userID)
+	if err != nil {
+		http.Error(w, "unable to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
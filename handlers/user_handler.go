@@ -4,39 +4,69 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"vigilant-spork/middleware"
 	"vigilant-spork/models"
 	"vigilant-spork/services"
+	"vigilant-spork/utils"
 )
 
 type UserHandler struct {
 	Service *services.UserService
 }
 
-func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
-	var signUp models.User
-	err := json.NewDecoder(r.Body).Decode(&signUp)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+type UserResponse struct {
+	ID         uuid.UUID `json:"id"`
+	Name       string    `json:"name"`
+	Email      string    `json:"email"`
+	Role       string    `json:"role"`
+	Disabled   bool      `json:"disabled"`
+	DisabledAt string    `json:"disabled_at,omitempty"`
+	CreatedAt  string    `json:"created_at"`
+}
+
+func toUserResponse(user *models.User) UserResponse {
+	response := UserResponse{
+		ID:        user.ID,
+		Name:      user.Name,
+		Email:     user.Email,
+		Role:      user.Role,
+		Disabled:  user.DisabledAt != nil,
+		CreatedAt: user.CreatedAt.Format("2006-01-02 15:04:05"),
 	}
+	if user.DisabledAt != nil {
+		response.DisabledAt = user.DisabledAt.Format("2006-01-02 15:04:05")
+	}
+	return response
+}
 
-	role := strings.ToLower(signUp.Role)
+type RegisterRequest struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
 
-	if role == "" {
-		role = "customer"
-	}
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
 
-	if role != "admin" && role != "customer" {
-		http.Error(w, "role must be either 'admin' or 'customer'", http.StatusBadRequest)
+func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
+	var req RegisterRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	signUp.Role = role
-
-	err = h.Service.RegisterUser(&signUp)
+	signUp := models.User{Name: req.Name, Email: req.Email, Password: req.Password}
+	err = h.Service.RegisterUser(r.Context(), &signUp)
 	if err != nil {
 		if errors.Is(err, services.ErrEmailExists) {
 			http.Error(w, err.Error(), http.StatusConflict)
@@ -51,21 +81,151 @@ func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
-	var login models.User
-	err := json.NewDecoder(r.Body).Decode(&login)
+	var req LoginRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	token, err := h.Service.Login(&login)
+	login := models.User{Email: req.Email, Password: req.Password}
+	result, err := h.Service.Login(r.Context(), &login, r.UserAgent(), utils.ClientIP(r))
+	if err != nil {
+		writeLoginError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if result.Challenge != nil {
+		json.NewEncoder(w).Encode(result.Challenge)
+		return
+	}
+	json.NewEncoder(w).Encode(result.Tokens)
+}
+
+func (h *UserHandler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.ChallengeToken == "" || req.Code == "" {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.Service.CompleteMFALogin(r.Context(), req.ChallengeToken, req.Code, r.UserAgent(), utils.ClientIP(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeLoginError(w, err)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(token)
+	json.NewEncoder(w).Encode(tokens)
+}
+
+func writeLoginError(w http.ResponseWriter, err error) {
+	var locked *services.LockedOutError
+	switch {
+	case errors.As(err, &locked):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, services.ErrInvalidCredentials), errors.Is(err, services.ErrInvalidMFACode),
+		errors.Is(err, services.ErrInvalidMFAChallenge):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, services.ErrAccountDisabled):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, "unable to log in", http.StatusInternalServerError)
+	}
+}
+
+func (h *UserHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Email == "" {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Service.ForgotPassword(r.Context(), req.Email)
+	if err != nil {
+		http.Error(w, "unable to send reset email", http.StatusInternalServerError)
+		return
+	}
+
+	// same answer whether or not the address is registered
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("if the address is registered, a reset link has been sent"))
+}
+
+func (h *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Token == "" {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Service.ResetPassword(r.Context(), req.Token, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrWeakPassword), errors.Is(err, services.ErrInvalidResetToken):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "unable to reset password", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("password has been reset, please log in again"))
+}
+
+func (h *UserHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "missing token", http.StatusBadRequest)
+		return
+	}
+
+	err := h.Service.VerifyEmail(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidVerifyToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "unable to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("email address verified"))
+}
+
+func (h *UserHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	err := h.Service.ResendVerification(r.Context(), middleware.GetUserID(r.Context()))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrAlreadyVerified):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, services.ErrResendThrottled):
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		default:
+			http.Error(w, "unable to send verification email", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("verification email sent"))
 }
 
 func (h *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
@@ -76,12 +236,214 @@ func (h *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.Service.UserRepo.AddTokenToBlacklist(token)
+	err := h.Service.Logout(r.Context(), token, middleware.GetUserID(r.Context()), middleware.GetSessionID(r.Context()))
 	if err != nil {
-		http.Error(w, "failed to blacklist token", http.StatusInternalServerError)
+		http.Error(w, "failed to log out", http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("logged out successfully"))
 }
+
+func (h *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	users, total, err := h.Service.ListUsers(r.Context(), page, limit)
+	if err != nil {
+		http.Error(w, "unable to list users", http.StatusInternalServerError)
+		return
+	}
+
+	type Metadata struct {
+		TotalItems  int64 `json:"total_items"`
+		TotalPages  int   `json:"total_pages"`
+		CurrentPage int   `json:"current_page"`
+	}
+
+	type Response struct {
+		Users    []UserResponse `json:"users"`
+		Metadata Metadata       `json:"metadata"`
+	}
+
+	response := Response{
+		Users: []UserResponse{},
+		Metadata: Metadata{
+			TotalItems:  total,
+			TotalPages:  (int(total) + limit - 1) / limit,
+			CurrentPage: page,
+		},
+	}
+	for i := range users {
+		response.Users = append(response.Users, toUserResponse(&users[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *UserHandler) UpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Service.ChangeRole(r.Context(), middleware.GetUserRole(r.Context()), userID, strings.ToLower(req.Role))
+	if err != nil {
+		h.writeAdminError(w, err, "unable to update role")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(toUserResponse(user))
+}
+
+func (h *UserHandler) DisableUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Service.DisableUser(ctx, middleware.GetUserID(ctx), middleware.GetUserRole(ctx), userID)
+	if err != nil {
+		h.writeAdminError(w, err, "unable to disable user")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(toUserResponse(user))
+}
+
+func (h *UserHandler) EnableUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Service.EnableUser(r.Context(), middleware.GetUserRole(r.Context()), userID)
+	if err != nil {
+		h.writeAdminError(w, err, "unable to enable user")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(toUserResponse(user))
+}
+
+type RoleResponse struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+func toRoleResponse(role *models.Role) RoleResponse {
+	response := RoleResponse{Name: role.Name, Description: role.Description, Permissions: []string{}}
+	for _, p := range role.Permissions {
+		response.Permissions = append(response.Permissions, p.Permission)
+	}
+	return response
+}
+
+func (h *UserHandler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Service.UnlockUser(r.Context(), middleware.GetUserRole(r.Context()), userID)
+	if err != nil {
+		h.writeAdminError(w, err, "unable to unlock user")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(toUserResponse(user))
+}
+
+func (h *UserHandler) ListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.Service.ListRoles(r.Context())
+	if err != nil {
+		http.Error(w, "unable to list roles", http.StatusInternalServerError)
+		return
+	}
+
+	response := []RoleResponse{}
+	for i := range roles {
+		response = append(response, toRoleResponse(&roles[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *UserHandler) SaveRole(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	role, err := h.Service.SaveRole(r.Context(), middleware.GetUserRole(r.Context()), mux.Vars(r)["name"], req.Description, req.Permissions)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidRoleName), errors.Is(err, services.ErrUnknownPermission):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, services.ErrBuiltInRole), errors.Is(err, services.ErrPrivilegeEscalation):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, "unable to save role", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(toRoleResponse(role))
+}
+
+func (h *UserHandler) writeAdminError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "user not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrInvalidRole):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrPrivilegeEscalation):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrLastAdmin), errors.Is(err, services.ErrCannotDisableSelf):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+}
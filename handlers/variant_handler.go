@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"net/http"
+	"vigilant-spork/models"
+	"vigilant-spork/services"
+)
+
+type VariantHandler struct {
+	Service *services.VariantService
+}
+
+type VariantResponse struct {
+	ID      uuid.UUID         `json:"id"`
+	SKU     string            `json:"sku"`
+	Price   string            `json:"price"`
+	Stock   int               `json:"stock"`
+	Options map[string]string `json:"options"`
+}
+
+type ProductVariantsResponse struct {
+	Options  []models.ProductOption `json:"options"`
+	Variants []VariantResponse      `json:"variants"`
+}
+
+// variantResponse describes a variant with its option values keyed by option
+// name and its price after falling back to the product's.
+func variantResponse(product *models.Product, v *models.Variant) VariantResponse {
+	names := map[uuid.UUID]string{}
+	for _, o := range product.Options {
+		names[o.ID] = o.Name
+	}
+
+	options := map[string]string{}
+	for _, value := range v.Options {
+		if name, ok := names[value.OptionID]; ok {
+			options[name] = value.Value
+		}
+	}
+
+	return VariantResponse{
+		ID:      v.ID,
+		SKU:     v.SKU,
+		Price:   fmt.Sprintf("%.2f", float64(v.UnitPrice(product.Price))/100),
+		Stock:   v.StockQuantity,
+		Options: options,
+	}
+}
+
+func (h *VariantHandler) GetVariants(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusNotFound)
+		return
+	}
+
+	product, err := h.Service.GetProductVariants(r.Context(), productID)
+	if err != nil {
+		writeVariantError(w, err, "unable to fetch variants")
+		return
+	}
+
+	response := ProductVariantsResponse{
+		Options:  product.Options,
+		Variants: []VariantResponse{},
+	}
+	if response.Options == nil {
+		response.Options = []models.ProductOption{}
+	}
+	for i := range product.Variants {
+		response.Variants = append(response.Variants, variantResponse(product, &product.Variants[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *VariantHandler) AddOption(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	option, err := h.Service.AddOption(r.Context(), productID, req.Name)
+	if err != nil {
+		writeVariantError(w, err, "unable to add option")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(option)
+}
+
+func (h *VariantHandler) DeleteOption(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusNotFound)
+		return
+	}
+	optionID, err := uuid.FromString(mux.Vars(r)["option_id"])
+	if err != nil {
+		http.Error(w, "invalid option ID", http.StatusNotFound)
+		return
+	}
+
+	err = h.Service.DeleteOption(r.Context(), productID, optionID)
+	if err != nil {
+		writeVariantError(w, err, "unable to delete option")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *VariantHandler) CreateVariant(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusNotFound)
+		return
+	}
+
+	var req services.VariantRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	variant, err := h.Service.CreateVariant(r.Context(), productID, req)
+	if err != nil {
+		writeVariantError(w, err, "unable to create variant")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(variant)
+}
+
+func (h *VariantHandler) UpdateVariant(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusNotFound)
+		return
+	}
+	variantID, err := uuid.FromString(mux.Vars(r)["variant_id"])
+	if err != nil {
+		http.Error(w, "invalid variant ID", http.StatusNotFound)
+		return
+	}
+
+	var req services.VariantUpdate
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	variant, err := h.Service.UpdateVariant(r.Context(), productID, variantID, req)
+	if err != nil {
+		writeVariantError(w, err, "unable to update variant")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(variant)
+}
+
+func (h *VariantHandler) DeleteVariant(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.FromString(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusNotFound)
+		return
+	}
+	variantID, err := uuid.FromString(mux.Vars(r)["variant_id"])
+	if err != nil {
+		http.Error(w, "invalid variant ID", http.StatusNotFound)
+		return
+	}
+
+	err = h.Service.DeleteVariant(r.Context(), productID, variantID)
+	if err != nil {
+		writeVariantError(w, err, "unable to delete variant")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeVariantError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrProductNotFound), errors.Is(err, services.ErrVariantNotFound),
+		errors.Is(err, services.ErrOptionNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrInvalidOption), errors.Is(err, services.ErrUnknownOption),
+		errors.Is(err, services.ErrInvalidVariant), errors.Is(err, services.ErrInvalidSKU):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrOptionTaken), errors.Is(err, services.ErrSKUTaken),
+		errors.Is(err, services.ErrDuplicateVariant), errors.Is(err, services.ErrLastVariant):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"time"
+	"vigilant-spork/payments"
+	"vigilant-spork/repository"
+	"vigilant-spork/services"
+)
+
+type WebhookHandler struct {
+	Service *services.OrderService
+	Secret  string
+}
+
+func (h *WebhookHandler) HandlePaymentEvent(w http.ResponseWriter, r *http.Request) {
+	provider := mux.Vars(r)["provider"]
+	if h.Service.Payments == nil || provider != h.Service.Payments.Name() {
+		http.Error(w, "unknown payment provider", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if !payments.VerifySignature([]byte(h.Secret), body, r.Header.Get(payments.TimestampHeader),
+		r.Header.Get(payments.SignatureHeader), time.Now()) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var event payments.WebhookEvent
+	err = json.Unmarshal(body, &event)
+	if err != nil || event.ID == "" {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Service.HandlePaymentEvent(r.Context(), provider, event)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrDuplicateEvent):
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("event already processed"))
+		case errors.Is(err, services.ErrStaleEvent):
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("event ignored"))
+		case errors.Is(err, services.ErrUnknownEventType):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			http.Error(w, "payment not found", http.StatusNotFound)
+		case errors.Is(err, services.ErrInvalidTransition), errors.Is(err, repository.ErrStatusConflict):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "unable to process event", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("event processed"))
+}
@@ -0,0 +1,79 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers transactional email. Real providers (SMTP, SES, ...) only
+// need to implement this; LogSender and FileSender are for local use.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// LogSender writes every message to the standard logger. Link tokens are
+// redacted because logs are kept and read far more widely than mailboxes; use
+// FileSender to follow the links locally.
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, msg Message) error {
+	log.Printf("mail to=%s subject=%q\n%s", msg.To, msg.Subject, redactTokens(msg.Body))
+	return nil
+}
+
+var tokenParam = regexp.MustCompile(`([?&]token=)[^&\s]+`)
+
+func redactTokens(body string) string {
+	return tokenParam.ReplaceAllString(body, "${1}REDACTED")
+}
+
+// FileSender writes every message to its own file in Dir so links can be
+// opened during local development.
+type FileSender struct {
+	Dir  string
+	From string
+}
+
+func (s *FileSender) Send(ctx context.Context, msg Message) error {
+	err := os.MkdirAll(s.Dir, 0o700)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), sanitize(msg.To))
+	contents := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n\r\n%s\r\n",
+		s.From, msg.To, msg.Subject, time.Now().Format(time.RFC1123Z), msg.Body)
+	return os.WriteFile(filepath.Join(s.Dir, name), []byte(contents), 0o600)
+}
+
+func sanitize(address string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, address)
+}
+
+// NewSender picks a sender by driver name: "log" or "file".
+func NewSender(driver, dir, from string) (Sender, error) {
+	switch driver {
+	case "", "log":
+		return LogSender{}, nil
+	case "file":
+		return &FileSender{Dir: dir, From: from}, nil
+	}
+	return nil, fmt.Errorf("unknown mail driver %q", driver)
+}
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"vigilant-spork/config"
 	"vigilant-spork/db"
 	"vigilant-spork/handlers"
+	"vigilant-spork/mail"
+	"vigilant-spork/middleware"
+	"vigilant-spork/payments"
 	"vigilant-spork/repository"
 	"vigilant-spork/routes"
 	"vigilant-spork/services"
@@ -13,19 +23,100 @@ import (
 
 func main() {
 
-	Db := db.InitDb()
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	Db := db.InitDb(cfg.DB)
+
+	if len(args) > 0 && args[0] == "migrate" {
+		err := runMigrate(Db, args[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	applied, err := db.MigrateUp(context.Background(), Db)
+	if err != nil {
+		log.Fatalf("unable to migrate schema: %v", err)
+	}
+	fmt.Printf("applied %d migration(s)\n", applied)
+
+	keys, err := loadKeys(cfg)
+	if err != nil {
+		log.Fatalf("unable to load signing keys: %v", err)
+	}
 
 	userRepo := &repository.UserRepo{Db: Db}
 	productRepo := &repository.ProductRepo{Db: Db}
 	cartRepo := &repository.CartRepo{Db: Db}
 	orderRepo := &repository.OrderRepo{Db: Db}
 	reviewRepo := &repository.ReviewRepo{Db: Db}
+	idempotencyRepo := &repository.IdempotencyRepo{Db: Db}
+	sessionRepo := &repository.SessionRepo{Db: Db}
+	roleRepo := &repository.RoleRepo{Db: Db}
+	loginAttemptRepo := &repository.LoginAttemptRepo{Db: Db}
+	mfaRepo := &repository.MFARepo{Db: Db}
+	addressRepo := &repository.AddressRepo{Db: Db}
+	categoryRepo := &repository.CategoryRepo{Db: Db}
+	variantRepo := &repository.VariantRepo{Db: Db}
+
+	sessionService := &services.SessionService{
+		SessionRepo:     sessionRepo,
+		UserRepo:        userRepo,
+		Keys:            keys,
+		AccessTokenTTL:  cfg.AccessTokenTTL,
+		RefreshTokenTTL: cfg.RefreshTokenTTL,
+	}
+	mailer, err := mail.NewSender(cfg.Mail.Driver, cfg.Mail.Dir, cfg.Mail.From)
+	if err != nil {
+		log.Fatalf("unable to configure mail: %v", err)
+	}
+
+	mfaService := &services.MFAService{
+		MFARepo:         mfaRepo,
+		UserRepo:        userRepo,
+		SessionRepo:     sessionRepo,
+		RoleRepo:        roleRepo,
+		Issuer:          cfg.MFAIssuer,
+		RequireAdminMFA: cfg.RequireAdminMFA,
+	}
+	userService := &services.UserService{
+		UserRepo: userRepo,
+		RoleRepo: roleRepo,
+		Sessions: sessionService,
+		Guard: &services.LoginGuard{
+			Attempts:            loginAttemptRepo,
+			AccountFreeAttempts: cfg.Login.AccountFreeAttempts,
+			IPFreeAttempts:      cfg.Login.IPFreeAttempts,
+			MaxLockout:          cfg.Login.MaxLockout,
+		},
+		MFA:              mfaService,
+		Mail:             mailer,
+		AppURL:           cfg.AppURL,
+		PasswordResetTTL: cfg.PasswordResetTTL,
+		VerificationTTL:  cfg.VerificationTTL,
+	}
+	if cfg.Admin.Email != "" {
+		created, err := userService.BootstrapAdmin(context.Background(), cfg.Admin.Name, cfg.Admin.Email, cfg.Admin.Password)
+		if err != nil {
+			log.Fatalf("unable to bootstrap admin: %v", err)
+		}
+		if created {
+			fmt.Printf("created admin account %s\n", cfg.Admin.Email)
+		}
+	}
 
-	userService := &services.UserService{UserRepo: userRepo}
-	productService := &services.ProductService{ProductRepo: productRepo}
+	productService := &services.ProductService{ProductRepo: productRepo, CategoryRepo: categoryRepo, VariantRepo: variantRepo}
+	variantService := &services.VariantService{VariantRepo: variantRepo, ProductRepo: productRepo}
+	categoryService := &services.CategoryService{CategoryRepo: categoryRepo}
 	cartService := &services.CartService{CartRepo: cartRepo,
-		ProductRepo: productRepo}
-	orderService := &services.OrderService{OrderRepo: orderRepo}
+		ProductRepo: productRepo, VariantRepo: variantRepo}
+	paymentProvider := payments.NewFakeProvider(payments.FakeMode(cfg.FakePaymentMode))
+	orderService := &services.OrderService{OrderRepo: orderRepo, AddressRepo: addressRepo, Payments: paymentProvider}
+	addressService := &services.AddressService{AddressRepo: addressRepo}
 	reviewService := services.NewReviewService(reviewRepo, productRepo)
 
 	userHandler := &handlers.UserHandler{Service: userService}
@@ -33,12 +124,87 @@ func main() {
 	cartHandler := &handlers.CartHandler{Service: cartService}
 	orderHandler := &handlers.OrderHandler{Service: orderService}
 	reviewHandler := &handlers.ReviewHandler{Service: reviewService}
+	webhookHandler := &handlers.WebhookHandler{Service: orderService, Secret: cfg.PaymentWebhookSecret}
+
+	healthHandler := &handlers.HealthHandler{Db: Db}
+	sessionHandler := &handlers.SessionHandler{Service: sessionService}
+	keysHandler := &handlers.KeysHandler{Keys: keys}
+	mfaHandler := &handlers.MFAHandler{Service: mfaService}
+	profileHandler := &handlers.ProfileHandler{Service: userService}
+	addressHandler := &handlers.AddressHandler{Service: addressService}
+	categoryHandler := &handlers.CategoryHandler{Service: categoryService}
+	variantHandler := &handlers.VariantHandler{Service: variantService}
+
+	r := routes.SetupRouter(userHandler, productHandler, cartHandler, orderHandler, reviewHandler, webhookHandler,
+		healthHandler, sessionHandler, keysHandler, mfaHandler, profileHandler, addressHandler, categoryHandler,
+		variantHandler, userService, roleRepo, idempotencyRepo, cfg)
+
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           r,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go reconcilePayments(ctx, orderService, cfg.PaymentReconcileInterval)
+
+	go func() {
+		fmt.Println("server started!")
+		err := srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("failed to start server", err)
+		}
+	}()
 
-	r := routes.SetupRouter(userHandler, productHandler, cartHandler, orderHandler, reviewHandler, userService)
+	<-ctx.Done()
+	fmt.Println("shutting down, draining in-flight requests...")
+	healthHandler.SetDraining()
+	// keep serving while load balancers notice the failing readiness probe
+	time.Sleep(cfg.Server.DrainDelay)
 
-	err := http.ListenAndServe(":8080", r)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	err = srv.Shutdown(shutdownCtx)
 	if err != nil {
-		log.Fatal("failed to start server", err)
+		log.Printf("graceful shutdown failed: %v", err)
+	}
+
+	sqlDB, err := Db.DB()
+	if err == nil {
+		sqlDB.Close()
+	}
+	fmt.Println("server stopped")
+}
+
+// reconcilePayments periodically finishes payment work left behind by
+// provider timeouts or crashes, until ctx is cancelled. Payments touched in
+// the last minute are skipped because a request may still be handling them.
+func reconcilePayments(ctx context.Context, orders *services.OrderService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := orders.ReconcilePayments(ctx, time.Minute)
+			if err != nil {
+				log.Printf("reconciling payments: %v", err)
+			}
+		}
+	}
+}
+
+// loadKeys signs with the asymmetric keys in JWT_KEYS_DIR when it is set and
+// falls back to HS256 with JWT_SECRET otherwise.
+func loadKeys(cfg *config.Config) (*middleware.KeySet, error) {
+	if cfg.JWTKeysDir == "" {
+		return middleware.NewHMACKeySet(cfg.JWTSecret), nil
 	}
-	fmt.Println("server started!")
+	return middleware.LoadKeySet(cfg.JWTKeysDir, cfg.JWTSigningKID)
 }
@@ -10,8 +10,7 @@ import (
 	"net/http"
 	"strings"
 	"time"
-	"vigilant-spork/db"
-	"vigilant-spork/models"
+	"vigilant-spork/repository"
 	"vigilant-spork/utils"
 )
 
@@ -20,8 +19,17 @@ type contextKey string
 const UserIDKey contextKey = "userID"
 const UserRoleKey contextKey = "userRole"
 const JWTTokenKey contextKey = "jwtTokenString"
-
-func AuthMiddleware(secret string) func(http.Handler) http.Handler {
+const SessionIDKey contextKey = "sessionID"
+const PermissionsKey contextKey = "permissions"
+const EmailVerifiedKey contextKey = "emailVerified"
+const MFAPendingKey contextKey = "mfaPending"
+
+// AuthMiddleware authenticates the bearer token. When requireAdminMFA is set,
+// sessions of any role that grants permissions must have passed a second
+// factor before those permissions count: until then they can still use the
+// API as a customer (and enrol in MFA) but HasPermission is false for them.
+func AuthMiddleware(keys *KeySet, users repository.UserRepository, sessions repository.SessionRepository,
+	roles repository.RoleRepository, requireAdminMFA bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
@@ -31,7 +39,7 @@ func AuthMiddleware(secret string) func(http.Handler) http.Handler {
 			}
 
 			token := strings.TrimPrefix(authHeader, "Bearer ")
-			uid, role, err := ValidateJWT(token, secret)
+			uid, _, sid, err := ValidateJWT(token, keys)
 			if err != nil {
 				utils.ErrorJSON(w, http.StatusUnauthorized, err.Error())
 				return
@@ -43,7 +51,36 @@ func AuthMiddleware(secret string) func(http.Handler) http.Handler {
 				return
 			}
 
-			isBlacklisted, err := IsTokenBlacklisted(token)
+			sessionUUID, err := uuid.FromString(sid)
+			if err != nil {
+				utils.ErrorJSON(w, http.StatusUnauthorized, "invalid session in token")
+				return
+			}
+
+			session, err := sessions.GetActiveSession(r.Context(), sessionUUID)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				utils.ErrorJSON(w, http.StatusUnauthorized, "session has been revoked or has expired")
+				return
+			}
+			if err != nil {
+				utils.ErrorJSON(w, http.StatusInternalServerError, "error checking session")
+				return
+			}
+
+			// the role is read from the database rather than the token so that
+			// promotions, demotions and disabled accounts take effect immediately
+			user, err := users.GetUserByID(r.Context(), userUUID)
+			if err != nil {
+				utils.ErrorJSON(w, http.StatusUnauthorized, "user no longer exists")
+				return
+			}
+
+			if user.DisabledAt != nil {
+				utils.ErrorJSON(w, http.StatusForbidden, "account is disabled")
+				return
+			}
+
+			isBlacklisted, err := users.IsTokenBlacklisted(r.Context(), token)
 			if err != nil {
 				utils.ErrorJSON(w, http.StatusInternalServerError, "error checking token")
 				return
@@ -54,56 +91,97 @@ func AuthMiddleware(secret string) func(http.Handler) http.Handler {
 				return
 			}
 
+			permissions, err := roles.GetPermissions(r.Context(), user.Role)
+			if err != nil {
+				utils.ErrorJSON(w, http.StatusInternalServerError, "error loading permissions")
+				return
+			}
+
+			granted := map[string]bool{}
+			for _, p := range permissions {
+				granted[p] = true
+			}
+
 			ctx := context.WithValue(r.Context(), UserIDKey, userUUID)
-			ctx = context.WithValue(ctx, UserRoleKey, role)
+			ctx = context.WithValue(ctx, UserRoleKey, user.Role)
 			ctx = context.WithValue(ctx, JWTTokenKey, token)
+			ctx = context.WithValue(ctx, SessionIDKey, sessionUUID)
+			ctx = context.WithValue(ctx, PermissionsKey, granted)
+			ctx = context.WithValue(ctx, EmailVerifiedKey, user.EmailVerifiedAt != nil)
+			// every permission is a staff privilege, so the requirement follows
+			// the permissions a role grants rather than its name
+			ctx = context.WithValue(ctx, MFAPendingKey, requireAdminMFA && len(permissions) > 0 && !session.MFAVerified)
 
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-func GenerateJWT(secret string, userID uuid.UUID, role string) (string, error) {
+// RequirePermission rejects requests whose role does not grant permission. It
+// must run after AuthMiddleware.
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if pending, _ := r.Context().Value(MFAPendingKey).(bool); pending {
+				utils.ErrorJSON(w, http.StatusForbidden, "two-factor authentication is required for this role")
+				return
+			}
+			if !HasPermission(r.Context(), permission) {
+				utils.ErrorJSON(w, http.StatusForbidden, "missing permission "+permission)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireVerifiedEmail rejects users who have not confirmed their email
+// address. When enabled is false it lets every request through.
+func RequireVerifiedEmail(enabled bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !enabled {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			verified, _ := r.Context().Value(EmailVerifiedKey).(bool)
+			if !verified {
+				utils.ErrorJSON(w, http.StatusForbidden, "please verify your email address first")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func GenerateJWT(keys *KeySet, userID uuid.UUID, role string, sessionID uuid.UUID, ttl time.Duration) (string, error) {
 	claims := jwt.MapClaims{
 		"sub":  userID.String(),
 		"role": role,
-		"exp":  time.Now().Add(time.Hour * 24).Unix(),
+		"sid":  sessionID.String(),
+		"exp":  time.Now().Add(ttl).Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	return keys.Sign(claims)
 }
 
-func ValidateJWT(tokenStr string, secret string) (string, string, error) {
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
-
-	if err != nil || !token.Valid {
-		return "", "", err
+func ValidateJWT(tokenStr string, keys *KeySet) (string, string, string, error) {
+	token, err := keys.Parse(tokenStr)
+	if err != nil {
+		return "", "", "", err
+	}
+	if !token.Valid {
+		return "", "", "", fmt.Errorf("invalid token")
 	}
 
 	if claims, ok := token.Claims.(jwt.MapClaims); ok {
 		uid, _ := claims["sub"].(string)
 		role, _ := claims["role"].(string)
+		sid, _ := claims["sid"].(string)
 
-		return uid, role, nil
+		return uid, role, sid, nil
 	}
 
-	return "", "", fmt.Errorf("invalid sub claim")
-}
-
-func IsTokenBlacklisted(token string) (bool, error) {
-	var entry models.BlacklistedToken
-	err := db.Db.Where("token = ?", token).First(&entry).Error
-
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return false, nil
-	}
-	if err != nil {
-		return false, err
-	}
-	return true, nil
+	return "", "", "", fmt.Errorf("invalid sub claim")
 }
 
 func GetUserID(ctx context.Context) uuid.UUID {
@@ -129,3 +207,21 @@ func GetToken(ctx context.Context) string {
 	}
 	return ""
 }
+
+func GetSessionID(ctx context.Context) uuid.UUID {
+	val := ctx.Value(SessionIDKey)
+	if id, ok := val.(uuid.UUID); ok {
+		return id
+	}
+	return uuid.Nil
+}
+
+// HasPermission reports whether the caller's role grants permission. It is
+// false for every permission while a mandatory second factor is pending.
+func HasPermission(ctx context.Context, permission string) bool {
+	if pending, _ := ctx.Value(MFAPendingKey).(bool); pending {
+		return false
+	}
+	granted, ok := ctx.Value(PermissionsKey).(map[string]bool)
+	return ok && granted[permission]
+}
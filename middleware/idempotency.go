@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+	"vigilant-spork/models"
+	"vigilant-spork/repository"
+	"vigilant-spork/utils"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const idempotencyKeyTTL = 24 * time.Hour
+
+// idempotencyLease is how long a key may stay in progress before it is
+// treated as abandoned by a crashed request and handed to a retry. It is
+// well beyond the server's write timeout, so a live request never loses its
+// key.
+const idempotencyLease = 5 * time.Minute
+
+// maxIdempotentBodyBytes caps the request body buffered for hashing.
+const maxIdempotentBodyBytes = 1 << 20
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Idempotency replays the stored response when a request is retried with the
+// same Idempotency-Key header. It must run after AuthMiddleware because keys
+// are scoped per user. Requests without the header pass straight through.
+func Idempotency(repo repository.IdempotencyRepository) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > 255 {
+				utils.ErrorJSON(w, http.StatusBadRequest, "idempotency key too long")
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodyBytes))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				utils.ErrorJSON(w, http.StatusRequestEntityTooLarge, "request body too large")
+				return
+			}
+			if err != nil {
+				utils.ErrorJSON(w, http.StatusBadRequest, "unable to read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			hash := sha256.New()
+			hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+			hash.Write(body)
+
+			record := &models.IdempotencyKey{
+				Key:         key,
+				UserID:      GetUserID(r.Context()),
+				Method:      r.Method,
+				Path:        r.URL.Path,
+				RequestHash: hex.EncodeToString(hash.Sum(nil)),
+			}
+
+			ctx := r.Context()
+			created, err := repo.CreateKey(ctx, record)
+			if err != nil {
+				utils.ErrorJSON(w, http.StatusInternalServerError, "error storing idempotency key")
+				return
+			}
+
+			if !created {
+				existing, err := repo.GetKey(ctx, record.UserID, key)
+				if err != nil {
+					utils.ErrorJSON(w, http.StatusInternalServerError, "error loading idempotency key")
+					return
+				}
+
+				expired := time.Since(existing.CreatedAt) > idempotencyKeyTTL
+				abandoned := !existing.Completed && existing.RequestHash == record.RequestHash &&
+					time.Since(existing.UpdatedAt) > idempotencyLease
+				if expired || abandoned {
+					now := time.Now()
+					created, err = repo.ReclaimKey(ctx, record, now.Add(-idempotencyKeyTTL), now.Add(-idempotencyLease))
+					if err != nil {
+						utils.ErrorJSON(w, http.StatusInternalServerError, "error storing idempotency key")
+						return
+					}
+					if !created {
+						// another retry reclaimed it first; answer from its state
+						existing, err = repo.GetKey(ctx, record.UserID, key)
+						if err != nil {
+							utils.ErrorJSON(w, http.StatusInternalServerError, "error loading idempotency key")
+							return
+						}
+					}
+				}
+
+				if !created {
+					switch {
+					case existing.RequestHash != record.RequestHash:
+						utils.ErrorJSON(w, http.StatusUnprocessableEntity, "idempotency key reused with a different request")
+					case !existing.Completed:
+						utils.ErrorJSON(w, http.StatusConflict, "a request with this idempotency key is still in progress")
+					default:
+						if existing.ContentType != "" {
+							w.Header().Set("Content-Type", existing.ContentType)
+						}
+						w.Header().Set("Idempotent-Replayed", "true")
+						w.WriteHeader(existing.StatusCode)
+						w.Write(existing.ResponseBody)
+					}
+					return
+				}
+			}
+
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+
+			// the client may already be gone, but the outcome still has to be recorded
+			saveCtx := context.WithoutCancel(ctx)
+
+			// server errors are not cached so the client can safely retry
+			if rec.status >= http.StatusInternalServerError {
+				err = repo.DeleteKey(saveCtx, record.UserID, key)
+				if err != nil {
+					log.Printf("releasing idempotency key %q for user %s: %v", key, record.UserID, err)
+				}
+				return
+			}
+
+			record.StatusCode = rec.status
+			record.ContentType = rec.Header().Get("Content-Type")
+			record.ResponseBody = rec.body.Bytes()
+			err = repo.SaveResponse(saveCtx, record)
+			if err != nil {
+				log.Printf("saving idempotent response for key %q, user %s: %v", key, record.UserID, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var ErrUnknownKey = errors.New("token signed with an unknown key")
+
+type verificationKey struct {
+	method jwt.SigningMethod
+	key    interface{}
+}
+
+// KeySet signs tokens with one key and accepts tokens signed by any of its
+// verification keys, which is what allows keys to be rotated without logging
+// everybody out.
+type KeySet struct {
+	signingKID    string
+	signingKey    interface{}
+	signingMethod jwt.SigningMethod
+	verifyKeys    map[string]verificationKey
+}
+
+// NewHMACKeySet signs and verifies with a shared secret (HS256). Such tokens
+// carry no kid and the key is never published in the JWKS.
+func NewHMACKeySet(secret string) *KeySet {
+	return &KeySet{
+		signingKey:    []byte(secret),
+		signingMethod: jwt.SigningMethodHS256,
+		verifyKeys: map[string]verificationKey{
+			"": {method: jwt.SigningMethodHS256, key: []byte(secret)},
+		},
+	}
+}
+
+// LoadKeySet reads every <kid>.pem file in dir. Private keys (RSA or Ed25519,
+// PKCS#8 or PKCS#1) and public keys (PKIX or PKCS#1) are all accepted for
+// verification; signingKID names the private key used to sign new tokens.
+// To rotate, add a new key, switch signingKID to it and keep the old public
+// key around until tokens signed with it have expired.
+func LoadKeySet(dir, signingKID string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := &KeySet{
+		signingKID: signingKID,
+		verifyKeys: map[string]verificationKey{},
+	}
+
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		private, public, err := parseKey(contents)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+
+		method, err := methodFor(public)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+		keys.verifyKeys[kid] = verificationKey{method: method, key: public}
+
+		if kid == signingKID {
+			if private == nil {
+				return nil, fmt.Errorf("signing key %s is not a private key", kid)
+			}
+			keys.signingKey = private
+			keys.signingMethod = method
+		}
+	}
+
+	if keys.signingKey == nil {
+		return nil, fmt.Errorf("signing key %q not found in %s", signingKID, dir)
+	}
+	return keys, nil
+}
+
+func parseKey(contents []byte) (interface{}, interface{}, error) {
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, nil, errors.New("no PEM data found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			return k, &k.PublicKey, nil
+		case ed25519.PrivateKey:
+			return k, k.Public(), nil
+		}
+		return nil, nil, fmt.Errorf("unsupported private key type %T", key)
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, &key.PublicKey, nil
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, key, nil
+	case "RSA PUBLIC KEY":
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, key, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+func methodFor(public interface{}) (jwt.SigningMethod, error) {
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", public)
+}
+
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.signingMethod, claims)
+	if k.signingKID != "" {
+		token.Header["kid"] = k.signingKID
+	}
+	return token.SignedString(k.signingKey)
+}
+
+// Parse verifies the token against the key named by its kid header and
+// rejects any token whose alg does not match that key.
+func (k *KeySet) Parse(tokenStr string) (*jwt.Token, error) {
+	return jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		vk, ok := k.verifyKeys[kid]
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		if token.Method.Alg() != vk.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		return vk.key, nil
+	})
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public verification keys. Shared HMAC secrets are never
+// included.
+func (k *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for kid, vk := range k.verifyKeys {
+		switch pub := vk.key.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				Alg: vk.method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: kid,
+				Use: "sig",
+				Alg: vk.method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+	return set
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// Address is an entry in a user's address book. At most one address per user
+// is the default, which checkout falls back to when none is chosen.
+type Address struct {
+	ID         uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	UserID     uuid.UUID `gorm:"type:uuid;index" json:"user_id"`
+	Label      string    `json:"label"`
+	FullName   string    `json:"full_name"`
+	Line1      string    `json:"line1"`
+	Line2      string    `json:"line2"`
+	City       string    `json:"city"`
+	Region     string    `json:"region"`
+	PostalCode string    `json:"postal_code"`
+	Country    string    `json:"country"`
+	Phone      string    `json:"phone"`
+	IsDefault  bool      `json:"is_default"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// OrderAddress is the copy of an address stored on an order at checkout. It
+// never changes afterwards, whatever happens to the address book.
+type OrderAddress struct {
+	FullName   string `json:"full_name"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+	Phone      string `json:"phone"`
+}
+
+func (a *Address) Snapshot() OrderAddress {
+	return OrderAddress{
+		FullName:   a.FullName,
+		Line1:      a.Line1,
+		Line2:      a.Line2,
+		City:       a.City,
+		Region:     a.Region,
+		PostalCode: a.PostalCode,
+		Country:    a.Country,
+		Phone:      a.Phone,
+	}
+}
@@ -8,7 +8,7 @@ import (
 type Cart struct {
 	ID     uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
 	UserID uuid.UUID  `json:"user_id"`
-	User   User       `gorm:"foreignKey:UserID" json:"user"`
+	User   User       `gorm:"foreignKey:UserID" json:"-"`
 	Items  []CartItem `gorm:"foreignKey:CartID"`
 	Total  int64      `json:"total_price"`
 }
@@ -18,6 +18,8 @@ type CartItem struct {
 	CartID    uuid.UUID `json:"cart_id"`
 	ProductID uuid.UUID `json:"product_id"`
 	Product   Product   `gorm:"foreignKey:ProductID"`
+	VariantID uuid.UUID `gorm:"type:uuid" json:"variant_id"`
+	Variant   Variant   `gorm:"foreignKey:VariantID"`
 	Quantity  int       `json:"quantity"`
 	UnitPrice int64     `json:"unit_price"`
 	CreatedAt time.Time `json:"created_at"`
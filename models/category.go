@@ -0,0 +1,18 @@
+package models
+
+import (
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// Category is a node in the catalogue tree. Root categories have no parent.
+// Children is filled in when the tree is assembled and is not a column.
+type Category struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	ParentID  *uuid.UUID `gorm:"type:uuid;index" json:"parent_id"`
+	Name      string     `json:"name"`
+	Slug      string     `gorm:"uniqueIndex" json:"slug"`
+	Children  []Category `gorm:"-" json:"children,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
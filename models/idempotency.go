@@ -0,0 +1,20 @@
+package models
+
+import (
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+type IdempotencyKey struct {
+	Key          string    `gorm:"primaryKey" json:"key"`
+	UserID       uuid.UUID `gorm:"type:uuid;primaryKey" json:"user_id"`
+	Method       string    `json:"method"`
+	Path         string    `json:"path"`
+	RequestHash  string    `json:"request_hash"`
+	Completed    bool      `json:"completed"`
+	StatusCode   int       `json:"status_code"`
+	ContentType  string    `json:"content_type"`
+	ResponseBody []byte    `json:"response_body"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
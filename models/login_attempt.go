@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// LoginAttempt counts recent failed logins for one key, either an account
+// ("account:<email>") or a client address ("ip:<addr>").
+type LoginAttempt struct {
+	Key           string     `gorm:"primaryKey" json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until"`
+}
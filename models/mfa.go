@@ -0,0 +1,31 @@
+package models
+
+import (
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// UserMFA is a user's TOTP enrolment. It only protects logins once
+// ConfirmedAt is set, i.e. after the user proved their app produces codes.
+type UserMFA struct {
+	UserID      uuid.UUID  `gorm:"type:uuid;primaryKey" json:"user_id"`
+	Secret      string     `json:"-"`
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+	// LastCounter is the time step of the last accepted code, so a code
+	// cannot be replayed within its validity window.
+	LastCounter int64     `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (UserMFA) TableName() string {
+	return "user_mfa"
+}
+
+type RecoveryCode struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	UserID    uuid.UUID  `gorm:"type:uuid;index" json:"user_id"`
+	CodeHash  string     `json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
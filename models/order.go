@@ -5,22 +5,82 @@ import (
 	"time"
 )
 
+const (
+	OrderStatusPending    = "PENDING"
+	OrderStatusPlaced     = "PLACED"
+	OrderStatusAuthorized = "AUTHORIZED"
+	OrderStatusPaid       = "PAID"
+	OrderStatusShipped    = "SHIPPED"
+	OrderStatusDelivered  = "DELIVERED"
+	OrderStatusCancelled  = "CANCELLED"
+	OrderStatusRefunded   = "REFUNDED"
+)
+
+// OrderTransitions lists, for each order status, the statuses it may move to.
+// AUTHORIZED means the payment has been authorized and is waiting for capture.
+var OrderTransitions = map[string][]string{
+	OrderStatusPending:    {OrderStatusPlaced, OrderStatusCancelled},
+	OrderStatusPlaced:     {OrderStatusAuthorized, OrderStatusPaid, OrderStatusCancelled},
+	OrderStatusAuthorized: {OrderStatusPaid, OrderStatusCancelled},
+	OrderStatusPaid:       {OrderStatusShipped, OrderStatusCancelled, OrderStatusRefunded},
+	OrderStatusShipped:    {OrderStatusDelivered, OrderStatusRefunded},
+	OrderStatusDelivered:  {OrderStatusRefunded},
+	OrderStatusCancelled:  {},
+	OrderStatusRefunded:   {},
+}
+
+func IsValidOrderStatus(status string) bool {
+	_, ok := OrderTransitions[status]
+	return ok
+}
+
+func CanTransitionOrder(from, to string) bool {
+	for _, next := range OrderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
 type Order struct {
-	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
-	UserID    uuid.UUID `json:"user_id"`
-	User      User      `gorm:"foreignKey:UserID" json:"user"`
-	Total     int64     `json:"total"`
-	Status    string    `json:"status"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID              uuid.UUID    `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	UserID          uuid.UUID    `json:"user_id"`
+	User            User         `gorm:"foreignKey:UserID" json:"-"`
+	Total           int64        `json:"total"`
+	Status          string       `json:"status"`
+	ShippingAddress OrderAddress `gorm:"embedded;embeddedPrefix:shipping_" json:"shipping_address"`
+	BillingAddress  OrderAddress `gorm:"embedded;embeddedPrefix:billing_" json:"billing_address"`
+	RestockedAt     *time.Time   `json:"restocked_at"`
+	CreatedAt       time.Time    `json:"created_at"`
+	UpdatedAt       time.Time    `json:"updated_at"`
 }
 
+// OrderItem keeps a snapshot of the product and variant as they were at
+// checkout so that past orders still render after the product is renamed,
+// repriced or deleted. VariantID is nil only if the variant was already gone
+// when variants were introduced.
 type OrderItem struct {
-	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
-	OrderID   uuid.UUID `json:"order_id"`
-	ProductID uuid.UUID `json:"product_id"`
-	Quantity  int       `json:"quantity"`
-	UnitPrice int64     `json:"unit_price"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID              uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	OrderID         uuid.UUID  `json:"order_id"`
+	ProductID       uuid.UUID  `json:"product_id"`
+	VariantID       *uuid.UUID `gorm:"type:uuid" json:"variant_id"`
+	ProductName     string     `json:"product_name"`
+	ProductCategory string     `json:"product_category"`
+	SKU             string     `json:"sku"`
+	VariantLabel    string     `json:"variant_label"`
+	Quantity        int        `json:"quantity"`
+	UnitPrice       int64      `json:"unit_price"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+type OrderStatusHistory struct {
+	ID         uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	OrderID    uuid.UUID `gorm:"type:uuid;index" json:"order_id"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	ChangedBy  uuid.UUID `gorm:"type:uuid" json:"changed_by"`
+	Note       string    `json:"note"`
+	CreatedAt  time.Time `json:"created_at"`
 }
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+const (
+	PaymentStatusPending    = "PENDING"
+	PaymentStatusAuthorized = "AUTHORIZED"
+	PaymentStatusCaptured   = "CAPTURED"
+	PaymentStatusDeclined   = "DECLINED"
+	PaymentStatusVoided     = "VOIDED"
+	PaymentStatusRefunded   = "REFUNDED"
+)
+
+type Payment struct {
+	ID            uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	OrderID       uuid.UUID `gorm:"type:uuid;index" json:"order_id"`
+	Order         Order     `gorm:"foreignKey:OrderID" json:"-"`
+	Provider      string    `json:"provider"`
+	ProviderRef   string    `gorm:"index" json:"provider_ref"`
+	Amount        int64     `json:"amount"`
+	Status        string    `json:"status"`
+	FailureReason string    `json:"failure_reason"`
+	// PendingStatus is the order status this payment is being settled for.
+	// It is recorded before the provider is called and cleared once the
+	// order has moved; a payment left with it set is finished by
+	// OrderService.ReconcilePayments.
+	PendingStatus string    `json:"pending_status"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// PaymentEvent records every provider webhook that has been processed so that
+// redelivered events are ignored.
+type PaymentEvent struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Provider  string    `gorm:"uniqueIndex:idx_payment_events_provider_event" json:"provider"`
+	EventID   string    `gorm:"uniqueIndex:idx_payment_events_provider_event" json:"event_id"`
+	Type      string    `json:"type"`
+	PaymentID uuid.UUID `gorm:"type:uuid" json:"payment_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -5,18 +5,26 @@ import (
 	"time"
 )
 
+// Product is what the catalogue lists. Price is the base price, which
+// variants may override. PriceFrom, the lowest price any variant sells at,
+// and StockQuantity, the total stock of all its variants, are kept in step
+// by the repositories.
 type Product struct {
-	ID            uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
-	Name          string     `json:"name"`
-	Description   string     `gorm:"type:text" json:"description"`
-	Category      string     `json:"category"`
-	Price         int64      `json:"price"`
-	StockQuantity int        `json:"stockQuantity"`
-	Rating        int        `json:"rating"`
-	ReviewCount   int64      `json:"reviewCount"`
-	Reviews       []Review   `gorm:"foreignKey:ProductID" json:"reviews,omitempty"`
-	Data          string     `json:"data"`
-	CreatedAt     time.Time  `json:"created_at"`
-	UpdatedAt     time.Time  `json:"updated_at"`
-	DeletedAt     *time.Time `json:"deleted_at"`
+	ID            uuid.UUID       `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Name          string          `json:"name"`
+	Description   string          `gorm:"type:text" json:"description"`
+	CategoryID    *uuid.UUID      `gorm:"type:uuid;index" json:"category_id"`
+	Category      *Category       `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
+	Price         int64           `json:"price"`
+	PriceFrom     int64           `json:"price_from"`
+	StockQuantity int             `json:"stockQuantity"`
+	Rating        int             `json:"rating"`
+	ReviewCount   int64           `json:"reviewCount"`
+	Reviews       []Review        `gorm:"foreignKey:ProductID" json:"reviews,omitempty"`
+	Options       []ProductOption `gorm:"foreignKey:ProductID" json:"options,omitempty"`
+	Variants      []Variant       `gorm:"foreignKey:ProductID" json:"variants,omitempty"`
+	Data          string          `json:"data"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+	DeletedAt     *time.Time      `json:"deleted_at"`
 }
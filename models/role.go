@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+const (
+	PermissionCatalogWrite    = "catalog:write"
+	PermissionOrdersFulfil    = "orders:fulfil"
+	PermissionReviewsModerate = "reviews:moderate"
+	PermissionUsersManage     = "users:manage"
+)
+
+// Permissions lists every permission the code checks. Roles are data and can
+// be added at runtime, but they can only be granted permissions from here.
+var Permissions = []string{
+	PermissionCatalogWrite,
+	PermissionOrdersFulfil,
+	PermissionReviewsModerate,
+	PermissionUsersManage,
+}
+
+func IsKnownPermission(permission string) bool {
+	for _, p := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+type Role struct {
+	Name        string           `gorm:"primaryKey" json:"name"`
+	Description string           `json:"description"`
+	Permissions []RolePermission `gorm:"foreignKey:Role;references:Name" json:"-"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
+
+type RolePermission struct {
+	Role       string `gorm:"primaryKey"`
+	Permission string `gorm:"primaryKey"`
+}
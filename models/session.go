@@ -0,0 +1,32 @@
+package models
+
+import (
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// Session is one logged-in device. Its refresh tokens are rotated on every
+// use; all of them belong to the same session (token family). MFAVerified
+// records whether the session was opened, or later confirmed, with a second
+// factor.
+type Session struct {
+	ID          uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	UserID      uuid.UUID  `gorm:"type:uuid;index" json:"user_id"`
+	UserAgent   string     `json:"user_agent"`
+	IPAddress   string     `json:"ip_address"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	LastUsedAt  time.Time  `json:"last_used_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	MFAVerified bool       `json:"mfa_verified"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+type RefreshToken struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	SessionID uuid.UUID  `gorm:"type:uuid;index" json:"session_id"`
+	TokenHash string     `gorm:"uniqueIndex" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
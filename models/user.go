@@ -5,17 +5,26 @@ import (
 	"time"
 )
 
+const (
+	RoleAdmin    = "admin"
+	RoleCustomer = "customer"
+)
+
+// User is never written to responses directly; handlers map it to a DTO.
+// Password holds the bcrypt hash and is excluded from JSON regardless.
 type User struct {
-	ID        uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
-	Name      string     `json:"name"`
-	Email     string     `gorm:"unique"`
-	Password  string     `json:"password"`
-	Role      string     `json:"role"`
-	CartID    uuid.UUID  `json:"cart_id"`
-	OrderID   uuid.UUID  `json:"order_id"`
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
-	DeletedAt *time.Time `json:"deleted_at"`
+	ID              uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Name            string     `json:"name"`
+	Email           string     `gorm:"unique" json:"email"`
+	Password        string     `json:"-"`
+	Role            string     `json:"role"`
+	CartID          uuid.UUID  `json:"cart_id"`
+	OrderID         uuid.UUID  `json:"order_id"`
+	DisabledAt      *time.Time `json:"disabled_at"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	DeletedAt       *time.Time `json:"deleted_at"`
 }
 
 type BlacklistedToken struct {
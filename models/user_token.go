@@ -0,0 +1,27 @@
+package models
+
+import (
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposeMFAChallenge      = "mfa_challenge"
+	TokenPurposeEmailChange       = "email_change"
+)
+
+// UserToken is a single-use secret sent to the user out of band, e.g. in a
+// password reset email. Only the SHA-256 hash of the token is stored. Payload
+// carries purpose-specific data, e.g. the new address for an email change.
+type UserToken struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	UserID    uuid.UUID  `gorm:"type:uuid;index" json:"user_id"`
+	Purpose   string     `json:"purpose"`
+	TokenHash string     `gorm:"uniqueIndex" json:"-"`
+	Payload   string     `json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
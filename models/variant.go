@@ -0,0 +1,74 @@
+package models
+
+import (
+	"github.com/gofrs/uuid"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProductOption is an axis a product varies along, such as "Size".
+type ProductOption struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	ProductID uuid.UUID `gorm:"type:uuid;index" json:"product_id"`
+	Name      string    `json:"name"`
+	Position  int       `json:"position"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Variant is the purchasable unit of a product: one SKU with its own stock.
+// Price overrides the product price when set. Every product has at least one
+// variant; a product without options has a single variant with no values.
+type Variant struct {
+	ID            uuid.UUID       `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	ProductID     uuid.UUID       `gorm:"type:uuid;index" json:"product_id"`
+	SKU           string          `gorm:"uniqueIndex" json:"sku"`
+	Price         *int64          `json:"price"`
+	StockQuantity int             `json:"stock_quantity"`
+	Options       []VariantOption `gorm:"foreignKey:VariantID" json:"options"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
+// VariantOption is a variant's value for one of its product's options. A
+// variant may leave an option unset, for example when the option was added
+// after the variant.
+type VariantOption struct {
+	VariantID uuid.UUID `gorm:"type:uuid;primaryKey" json:"variant_id"`
+	OptionID  uuid.UUID `gorm:"type:uuid;primaryKey" json:"option_id"`
+	Value     string    `json:"value"`
+}
+
+// DefaultSKU is the SKU given to the variant created along with a product.
+func DefaultSKU(productID uuid.UUID) string {
+	return "P-" + strings.ReplaceAll(productID.String(), "-", "")
+}
+
+// UnitPrice is the variant's price, falling back to the product's price.
+func (v *Variant) UnitPrice(productPrice int64) int64 {
+	if v.Price != nil {
+		return *v.Price
+	}
+	return productPrice
+}
+
+// VariantLabel describes a variant by its option values in option order,
+// e.g. "Size: M / Colour: Red". It is "" for a variant without values.
+func (p *Product) VariantLabel(v *Variant) string {
+	options := make([]ProductOption, len(p.Options))
+	copy(options, p.Options)
+	sort.Slice(options, func(i, j int) bool {
+		return options[i].Position < options[j].Position
+	})
+
+	var parts []string
+	for _, option := range options {
+		for _, value := range v.Options {
+			if value.OptionID == option.ID {
+				parts = append(parts, option.Name+": "+value.Value)
+			}
+		}
+	}
+	return strings.Join(parts, " / ")
+}
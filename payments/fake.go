@@ -0,0 +1,112 @@
+package payments
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type FakeMode string
+
+const (
+	FakeSucceed FakeMode = "succeed"
+	FakeDecline FakeMode = "decline"
+	FakeTimeout FakeMode = "timeout"
+)
+
+const (
+	fakeAuthorized = "authorized"
+	fakeCaptured   = "captured"
+	fakeVoided     = "voided"
+	fakeRefunded   = "refunded"
+)
+
+// FakeProvider is an in-memory gateway for local development and tests.
+// Mode controls how Authorize behaves. A FakeTimeout call blocks until the
+// context is done, like a gateway that never answers; a non-zero Delay makes
+// it give up after that long instead.
+type FakeProvider struct {
+	Mode  FakeMode
+	Delay time.Duration
+
+	mu       sync.Mutex
+	payments map[string]string
+}
+
+func NewFakeProvider(mode FakeMode) *FakeProvider {
+	if mode == "" {
+		mode = FakeSucceed
+	}
+	return &FakeProvider{
+		Mode:     mode,
+		payments: make(map[string]string),
+	}
+}
+
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *FakeProvider) Authorize(ctx context.Context, req AuthorizeRequest) (*Result, error) {
+	switch p.Mode {
+	case FakeDecline:
+		return nil, ErrDeclined
+	case FakeTimeout:
+		var giveUp <-chan time.Time
+		if p.Delay > 0 {
+			giveUp = time.After(p.Delay)
+		}
+		select {
+		case <-giveUp:
+		case <-ctx.Done():
+		}
+		return nil, ErrTimeout
+	}
+
+	reference := "fake_" + req.PaymentID.String()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.payments[reference]; !ok {
+		p.payments[reference] = fakeAuthorized
+	}
+	return &Result{Reference: reference}, nil
+}
+
+func (p *FakeProvider) Capture(ctx context.Context, reference string, amount int64) (*Result, error) {
+	return p.move(reference, fakeAuthorized, fakeCaptured)
+}
+
+func (p *FakeProvider) Refund(ctx context.Context, reference string, amount int64) (*Result, error) {
+	return p.move(reference, fakeCaptured, fakeRefunded)
+}
+
+func (p *FakeProvider) Void(ctx context.Context, reference string) (*Result, error) {
+	return p.move(reference, fakeAuthorized, fakeVoided)
+}
+
+func (p *FakeProvider) move(reference, from, to string) (*Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state, ok := p.payments[reference]
+	if !ok {
+		return nil, ErrUnknownReference
+	}
+	if state == to {
+		return &Result{Reference: reference}, nil
+	}
+	if state != from {
+		return nil, ErrInvalidState
+	}
+	p.payments[reference] = to
+	return &Result{Reference: reference}, nil
+}
+
+// State returns the fake gateway's state for a reference ("" if unknown), so
+// tests can check what the shop asked it to do.
+func (p *FakeProvider) State(reference string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.payments[reference]
+}
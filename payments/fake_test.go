@@ -0,0 +1,54 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid"
+	"testing"
+	"time"
+)
+
+func TestFakeTimeoutWaitsForDeadline(t *testing.T) {
+	p := NewFakeProvider(FakeTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := p.Authorize(ctx, AuthorizeRequest{PaymentID: uuid.Must(uuid.NewV4()), Amount: 100})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("error = %v, want %v", err, ErrTimeout)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("gave up after %v, before the deadline", elapsed)
+	}
+}
+
+func TestFakeOperationsCanBeRetried(t *testing.T) {
+	p := NewFakeProvider(FakeSucceed)
+	ctx := context.Background()
+	req := AuthorizeRequest{PaymentID: uuid.Must(uuid.NewV4()), Amount: 100}
+
+	first, err := p.Authorize(ctx, req)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	again, err := p.Authorize(ctx, req)
+	if err != nil || again.Reference != first.Reference {
+		t.Fatalf("repeated authorize = %v, %v; want reference %s", again, err, first.Reference)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = p.Capture(ctx, first.Reference, 100)
+		if err != nil {
+			t.Fatalf("capture #%d: %v", i+1, err)
+		}
+	}
+	if state := p.State(first.Reference); state != fakeCaptured {
+		t.Errorf("state = %s, want %s", state, fakeCaptured)
+	}
+
+	_, err = p.Void(ctx, first.Reference)
+	if !errors.Is(err, ErrInvalidState) {
+		t.Errorf("void after capture error = %v, want %v", err, ErrInvalidState)
+	}
+}
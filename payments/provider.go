@@ -0,0 +1,40 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid"
+)
+
+var (
+	ErrDeclined         = errors.New("payment declined")
+	ErrTimeout          = errors.New("payment provider timed out")
+	ErrUnknownReference = errors.New("unknown payment reference")
+	ErrInvalidState     = errors.New("payment is not in a valid state for this operation")
+)
+
+// PaymentProvider is implemented by every payment gateway the shop can take
+// money through. Amounts are in the smallest currency unit, like everywhere
+// else in the API.
+//
+// Every call may be repeated after a timeout or crash: Authorize must return
+// the existing authorization for a PaymentID it has already seen, and
+// Capture, Refund and Void must succeed for a payment already in the state
+// they would move it to.
+type PaymentProvider interface {
+	Name() string
+	Authorize(ctx context.Context, req AuthorizeRequest) (*Result, error)
+	Capture(ctx context.Context, reference string, amount int64) (*Result, error)
+	Refund(ctx context.Context, reference string, amount int64) (*Result, error)
+	Void(ctx context.Context, reference string) (*Result, error)
+}
+
+type AuthorizeRequest struct {
+	PaymentID uuid.UUID
+	OrderID   uuid.UUID
+	Amount    int64
+}
+
+type Result struct {
+	Reference string
+}
@@ -0,0 +1,67 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gofrs/uuid"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+)
+
+// WebhookTolerance is how far a delivery's timestamp may be from the current
+// time. Older deliveries are rejected as replays, so the event id
+// deduplication does not have to remember events forever.
+const WebhookTolerance = 5 * time.Minute
+
+const (
+	EventAuthorized = "payment.authorized"
+	EventCaptured   = "payment.captured"
+	EventFailed     = "payment.failed"
+	EventRefunded   = "payment.refunded"
+)
+
+// WebhookEvent is the notification body providers send to
+// /api/v1/webhooks/payments/{provider}. Either Reference or PaymentID must
+// identify the payment.
+type WebhookEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Reference string    `json:"reference"`
+	PaymentID uuid.UUID `json:"payment_id"`
+	Reason    string    `json:"reason"`
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of timestamp + "." + body, as
+// expected in the SignatureHeader. timestamp is the value of the
+// TimestampHeader, in Unix seconds.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks the signature of a delivery and that its timestamp
+// is within WebhookTolerance of now.
+func VerifySignature(secret, body []byte, timestamp, signature string, now time.Time) bool {
+	if len(secret) == 0 || signature == "" {
+		return false
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := now.Sub(time.Unix(unix, 0))
+	if age > WebhookTolerance || age < -WebhookTolerance {
+		return false
+	}
+	signature = strings.TrimPrefix(signature, "sha256=")
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
@@ -0,0 +1,39 @@
+package payments
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"id":"evt_1"}`)
+	now := time.Unix(1700000000, 0)
+	sent := strconv.FormatInt(now.Unix(), 10)
+
+	tests := []struct {
+		name      string
+		body      []byte
+		timestamp string
+		signature string
+		want      bool
+	}{
+		{"valid", body, sent, Sign(secret, sent, body), true},
+		{"prefixed", body, sent, "sha256=" + Sign(secret, sent, body), true},
+		{"tampered body", []byte(`{"id":"evt_2"}`), sent, Sign(secret, sent, body), false},
+		{"timestamp not signed", body, "1700000001", Sign(secret, sent, body), false},
+		{"too old", body, "1699999000", Sign(secret, "1699999000", body), false},
+		{"too far ahead", body, "1700001000", Sign(secret, "1700001000", body), false},
+		{"missing timestamp", body, "", Sign(secret, "", body), false},
+		{"missing signature", body, sent, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := VerifySignature(secret, tt.body, tt.timestamp, tt.signature, now)
+			if got != tt.want {
+				t.Errorf("VerifySignature = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
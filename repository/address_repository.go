@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"vigilant-spork/models"
+)
+
+type AddressRepository interface {
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
+	ListAddresses(ctx context.Context, userID uuid.UUID) ([]models.Address, error)
+	GetAddress(ctx context.Context, userID, addressID uuid.UUID) (*models.Address, error)
+	GetDefaultAddress(ctx context.Context, userID uuid.UUID) (*models.Address, error)
+	CreateAddress(ctx context.Context, address *models.Address) error
+	UpdateAddress(ctx context.Context, address *models.Address) error
+	DeleteAddress(ctx context.Context, userID, addressID uuid.UUID) error
+	ClearDefaultAddress(ctx context.Context, userID uuid.UUID) error
+	SetDefaultAddress(ctx context.Context, userID, addressID uuid.UUID) error
+}
+
+type AddressRepo struct {
+	Db *gorm.DB
+}
+
+func (r *AddressRepo) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return Transaction(ctx, r.Db, fn)
+}
+
+// ListAddresses returns the default address first, then the rest oldest first.
+func (r *AddressRepo) ListAddresses(ctx context.Context, userID uuid.UUID) ([]models.Address, error) {
+	db := conn(ctx, r.Db)
+	var addresses []models.Address
+	err := db.Where("user_id = ?", userID).Order("is_default DESC, created_at ASC").Find(&addresses).Error
+	if err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+// GetAddress only finds addresses owned by userID.
+func (r *AddressRepo) GetAddress(ctx context.Context, userID, addressID uuid.UUID) (*models.Address, error) {
+	db := conn(ctx, r.Db)
+	var address models.Address
+	err := db.Where("id = ? AND user_id = ?", addressID, userID).First(&address).Error
+	if err != nil {
+		return nil, err
+	}
+	return &address, nil
+}
+
+func (r *AddressRepo) GetDefaultAddress(ctx context.Context, userID uuid.UUID) (*models.Address, error) {
+	db := conn(ctx, r.Db)
+	var address models.Address
+	err := db.Where("user_id = ? AND is_default", userID).First(&address).Error
+	if err != nil {
+		return nil, err
+	}
+	return &address, nil
+}
+
+func (r *AddressRepo) CreateAddress(ctx context.Context, address *models.Address) error {
+	db := conn(ctx, r.Db)
+	err := db.Create(address).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *AddressRepo) UpdateAddress(ctx context.Context, address *models.Address) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(address).Where("user_id = ?", address.UserID).
+		Select("label", "full_name", "line1", "line2", "city", "region", "postal_code", "country", "phone", "updated_at").
+		Updates(address).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *AddressRepo) DeleteAddress(ctx context.Context, userID, addressID uuid.UUID) error {
+	db := conn(ctx, r.Db)
+	result := db.Where("id = ? AND user_id = ?", addressID, userID).Delete(&models.Address{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *AddressRepo) ClearDefaultAddress(ctx context.Context, userID uuid.UUID) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(&models.Address{}).Where("user_id = ? AND is_default", userID).Update("is_default", false).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetDefaultAddress marks the address as the default. The previous default
+// must be cleared first, in the same transaction.
+func (r *AddressRepo) SetDefaultAddress(ctx context.Context, userID, addressID uuid.UUID) error {
+	db := conn(ctx, r.Db)
+	result := db.Model(&models.Address{}).Where("id = ? AND user_id = ?", addressID, userID).Update("is_default", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -1,22 +1,23 @@
 package repository
 
 import (
+	"context"
 	"errors"
 	"github.com/gofrs/uuid"
 	"gorm.io/gorm"
-	"vigilant-spork/db"
+	"gorm.io/gorm/clause"
 	"vigilant-spork/models"
 )
 
 type CartRepository interface {
-	GetOrCreateCart(userID uuid.UUID) (*models.Cart, error)
-	AddItemToCart(productID, cartID uuid.UUID) error
-	GetCartItems(cartID uuid.UUID) ([]models.CartItem, error)
-	UpdateCartTotal(total int64, cartID uuid.UUID) error
-	GetCartByUserID(userID uuid.UUID) (*models.Cart, error)
-	GetCartItemsByCartID(cartID uuid.UUID) ([]models.CartItem, error)
-	UpdateItemQuantity(userID, productID uuid.UUID, quantity int) (*models.CartItem, error)
-	RemoveItemFromCart(cartID, productID uuid.UUID) error
+	GetOrCreateCart(ctx context.Context, userID uuid.UUID) (*models.Cart, error)
+	AddItemToCart(ctx context.Context, variantID, cartID uuid.UUID) error
+	GetCartItems(ctx context.Context, cartID uuid.UUID) ([]models.CartItem, error)
+	UpdateCartTotal(ctx context.Context, total int64, cartID uuid.UUID) error
+	GetCartByUserID(ctx context.Context, userID uuid.UUID) (*models.Cart, error)
+	GetCartItemsByCartID(ctx context.Context, cartID uuid.UUID) ([]models.CartItem, error)
+	UpdateItemQuantity(ctx context.Context, userID, variantID uuid.UUID, quantity int) (*models.CartItem, error)
+	RemoveItemFromCart(ctx context.Context, cartID, variantID uuid.UUID) error
 }
 
 type CartRepo struct {
@@ -25,15 +26,16 @@ type CartRepo struct {
 
 var ErrInvalidQuantity = errors.New("invalid quantity")
 
-func (r *CartRepo) GetOrCreateCart(userID uuid.UUID) (*models.Cart, error) {
+func (r *CartRepo) GetOrCreateCart(ctx context.Context, userID uuid.UUID) (*models.Cart, error) {
+	db := conn(ctx, r.Db)
 	var cart models.Cart
-	err := db.Db.Preload("User").Preload("Items").Where("user_id = ?", userID).First(&cart).Error
+	err := db.Preload("User").Preload("Items").Where("user_id = ?", userID).First(&cart).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		cart = models.Cart{
 			UserID: userID,
 			Total:  0,
 		}
-		err = db.Db.Create(&cart).Error
+		err = db.Create(&cart).Error
 		if err != nil {
 			return nil, err
 		}
@@ -45,42 +47,55 @@ func (r *CartRepo) GetOrCreateCart(userID uuid.UUID) (*models.Cart, error) {
 	return &cart, nil
 }
 
-func (r *CartRepo) AddItemToCart(productID, cartID uuid.UUID) error {
+// AddItemToCart adds one of the variant to the cart, or one more if it is
+// already there.
+func (r *CartRepo) AddItemToCart(ctx context.Context, variantID, cartID uuid.UUID) error {
+	db := conn(ctx, r.Db)
 	var cart models.Cart
-	err := db.Db.Where("id = ?", cartID).First(&cart).Error
+	err := db.Where("id = ?", cartID).First(&cart).Error
+	if err != nil {
+		return err
+	}
+
+	var variant models.Variant
+	err = db.Where("id = ?", variantID).First(&variant).Error
 	if err != nil {
 		return err
 	}
 
 	var product models.Product
-	err = db.Db.Where("id = ?", productID).First(&product).Error
+	err = db.Where("id = ?", variant.ProductID).First(&product).Error
 	if err != nil {
 		return err
 	}
 
 	var cartItem models.CartItem
-	err = db.Db.Where("cart_id = ? AND product_id = ?", cart.ID, productID).First(&cartItem).Error
+	err = db.Where("cart_id = ? AND variant_id = ?", cart.ID, variantID).First(&cartItem).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if variant.StockQuantity < 1 {
+			return ErrInsufficientStock
+		}
 		cartItem = models.CartItem{
 			CartID:    cart.ID,
-			ProductID: productID,
+			ProductID: product.ID,
+			VariantID: variant.ID,
 			Quantity:  1,
-			UnitPrice: product.Price,
+			UnitPrice: variant.UnitPrice(product.Price),
 		}
-		return db.Db.Create(&cartItem).Error
+		return db.Omit(clause.Associations).Create(&cartItem).Error
 	}
 	if err != nil {
 		return err
 	}
 
-	if product.StockQuantity < cartItem.Quantity+1 {
+	if variant.StockQuantity < cartItem.Quantity+1 {
 		return ErrInsufficientStock
 	}
 
 	cartItem.Quantity++
-	cartItem.UnitPrice = product.Price
+	cartItem.UnitPrice = variant.UnitPrice(product.Price)
 
-	err = db.Db.Save(&cartItem).Error
+	err = db.Save(&cartItem).Error
 	if err != nil {
 		return err
 	}
@@ -88,26 +103,29 @@ func (r *CartRepo) AddItemToCart(productID, cartID uuid.UUID) error {
 	return nil
 }
 
-func (r *CartRepo) GetCartItems(cartID uuid.UUID) ([]models.CartItem, error) {
+func (r *CartRepo) GetCartItems(ctx context.Context, cartID uuid.UUID) ([]models.CartItem, error) {
+	db := conn(ctx, r.Db)
 	var items []models.CartItem
-	err := db.Db.Where("cart_id = ?", cartID).Find(&items).Error
+	err := db.Where("cart_id = ?", cartID).Find(&items).Error
 	if err != nil {
 		return nil, err
 	}
 	return items, nil
 }
 
-func (r *CartRepo) UpdateCartTotal(total int64, cartID uuid.UUID) error {
-	err := db.Db.Model(&models.Cart{}).Where("id = ?", cartID).Update("total", total).Error
+func (r *CartRepo) UpdateCartTotal(ctx context.Context, total int64, cartID uuid.UUID) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(&models.Cart{}).Where("id = ?", cartID).Update("total", total).Error
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *CartRepo) GetCartByUserID(userID uuid.UUID) (*models.Cart, error) {
+func (r *CartRepo) GetCartByUserID(ctx context.Context, userID uuid.UUID) (*models.Cart, error) {
+	db := conn(ctx, r.Db)
 	var cart models.Cart
-	err := db.Db.Preload("Items.Product").Preload("User").Where("user_id = ?", userID).First(&cart).Error
+	err := db.Preload("Items.Product.Options").Preload("Items.Variant.Options").Preload("User").Where("user_id = ?", userID).First(&cart).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, gorm.ErrRecordNotFound
 	}
@@ -117,18 +135,20 @@ func (r *CartRepo) GetCartByUserID(userID uuid.UUID) (*models.Cart, error) {
 	return &cart, nil
 }
 
-func (r *CartRepo) GetCartItemsByCartID(cartID uuid.UUID) ([]models.CartItem, error) {
+func (r *CartRepo) GetCartItemsByCartID(ctx context.Context, cartID uuid.UUID) ([]models.CartItem, error) {
+	db := conn(ctx, r.Db)
 	var items []models.CartItem
-	err := db.Db.Preload("Product").Where("cart_id = ?", cartID).Find(&items).Error
+	err := db.Preload("Product").Preload("Variant").Where("cart_id = ?", cartID).Find(&items).Error
 	if err != nil {
 		return nil, err
 	}
 	return items, nil
 }
 
-func (r *CartRepo) UpdateItemQuantity(userID, productID uuid.UUID, quantity int) (*models.CartItem, error) {
+func (r *CartRepo) UpdateItemQuantity(ctx context.Context, userID, variantID uuid.UUID, quantity int) (*models.CartItem, error) {
+	db := conn(ctx, r.Db)
 	var cartItem models.CartItem
-	err := db.Db.Preload("Product").Joins("JOIN carts ON carts.id = cart_items.cart_id").Where("carts.user_id = ? AND cart_items.product_id =?", userID, productID).First(&cartItem).Error
+	err := db.Preload("Product.Options").Preload("Variant.Options").Joins("JOIN carts ON carts.id = cart_items.cart_id").Where("carts.user_id = ? AND cart_items.variant_id =?", userID, variantID).First(&cartItem).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, gorm.ErrRecordNotFound
 	}
@@ -140,22 +160,23 @@ func (r *CartRepo) UpdateItemQuantity(userID, productID uuid.UUID, quantity int)
 		return nil, ErrInvalidQuantity
 	}
 
-	if cartItem.Product.StockQuantity < quantity {
+	if cartItem.Variant.StockQuantity < quantity {
 		return nil, ErrInsufficientStock
 	}
 
 	cartItem.Quantity = quantity
 
-	err = db.Db.Save(&cartItem).Error
+	err = db.Model(&cartItem).Update("quantity", quantity).Error
 	if err != nil {
 		return nil, err
 	}
 	return &cartItem, nil
 }
 
-func (r *CartRepo) RemoveItemFromCart(cartID, productID uuid.UUID) error {
+func (r *CartRepo) RemoveItemFromCart(ctx context.Context, cartID, variantID uuid.UUID) error {
+	db := conn(ctx, r.Db)
 	var item models.CartItem
-	err := db.Db.Where("cart_id = ? AND product_id = ?", cartID, productID).First(&item).Error
+	err := db.Where("cart_id = ? AND variant_id = ?", cartID, variantID).First(&item).Error
 
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return gorm.ErrRecordNotFound
@@ -164,7 +185,7 @@ func (r *CartRepo) RemoveItemFromCart(cartID, productID uuid.UUID) error {
 		return err
 	}
 
-	err = db.Db.Delete(&item).Error
+	err = db.Delete(&item).Error
 	if err != nil {
 		return err
 	}
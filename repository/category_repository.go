@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"vigilant-spork/models"
+)
+
+type CategoryRepository interface {
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
+	LockTree(ctx context.Context) error
+	ListCategories(ctx context.Context) ([]models.Category, error)
+	GetCategory(ctx context.Context, id uuid.UUID) (*models.Category, error)
+	GetCategoryBySlug(ctx context.Context, slug string) (*models.Category, error)
+	CreateCategory(ctx context.Context, category *models.Category) error
+	UpdateCategory(ctx context.Context, category *models.Category) error
+	DeleteCategory(ctx context.Context, id uuid.UUID) error
+	GetDescendantIDs(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error)
+	CountChildren(ctx context.Context, id uuid.UUID) (int64, error)
+	CountProducts(ctx context.Context, id uuid.UUID) (int64, error)
+}
+
+type CategoryRepo struct {
+	Db *gorm.DB
+}
+
+// descendantsSQL selects the IDs of a category and everything below it. The
+// root is the category whose rootColumn (id or slug) equals the single
+// parameter. UNION rather than UNION ALL stops the recursion even if the
+// tree somehow contains a cycle.
+func descendantsSQL(rootColumn string) string {
+	return `WITH RECURSIVE tree AS (
+		SELECT id FROM categories WHERE ` + rootColumn + ` = ?
+		UNION
+		SELECT c.id FROM categories c JOIN tree t ON c.parent_id = t.id
+	) SELECT id FROM tree`
+}
+
+// categoryClosureSQL pairs every category (ancestor_id) with itself and with
+// each category below it (descendant_id), so that counts can be rolled up.
+const categoryClosureSQL = `WITH RECURSIVE closure AS (
+		SELECT id AS ancestor_id, id AS descendant_id FROM categories
+		UNION
+		SELECT cl.ancestor_id, c.id FROM categories c JOIN closure cl ON c.parent_id = cl.descendant_id
+	) SELECT ancestor_id, descendant_id FROM closure`
+
+func (r *CategoryRepo) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return Transaction(ctx, r.Db, fn)
+}
+
+// LockTree blocks other writers to categories until the transaction ends,
+// while still letting readers through. A cycle can come from two moves
+// touching different rows, so checking a move needs the whole tree to hold
+// still, not just the rows involved.
+func (r *CategoryRepo) LockTree(ctx context.Context) error {
+	db := conn(ctx, r.Db)
+	err := db.Exec("LOCK TABLE categories IN SHARE ROW EXCLUSIVE MODE").Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *CategoryRepo) ListCategories(ctx context.Context) ([]models.Category, error) {
+	db := conn(ctx, r.Db)
+	var categories []models.Category
+	err := db.Order("name ASC").Find(&categories).Error
+	if err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+func (r *CategoryRepo) GetCategory(ctx context.Context, id uuid.UUID) (*models.Category, error) {
+	db := conn(ctx, r.Db)
+	var category models.Category
+	err := db.Where("id = ?", id).First(&category).Error
+	if err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+func (r *CategoryRepo) GetCategoryBySlug(ctx context.Context, slug string) (*models.Category, error) {
+	db := conn(ctx, r.Db)
+	var category models.Category
+	err := db.Where("slug = ?", slug).First(&category).Error
+	if err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+func (r *CategoryRepo) CreateCategory(ctx context.Context, category *models.Category) error {
+	db := conn(ctx, r.Db)
+	err := db.Create(category).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *CategoryRepo) UpdateCategory(ctx context.Context, category *models.Category) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(category).Select("parent_id", "name", "slug", "updated_at").Updates(category).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *CategoryRepo) DeleteCategory(ctx context.Context, id uuid.UUID) error {
+	db := conn(ctx, r.Db)
+	err := db.Where("id = ?", id).Delete(&models.Category{}).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetDescendantIDs returns id and the IDs of every category below it.
+func (r *CategoryRepo) GetDescendantIDs(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error) {
+	db := conn(ctx, r.Db)
+	var ids []uuid.UUID
+	err := db.Raw(descendantsSQL("id"), id).Scan(&ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r *CategoryRepo) CountChildren(ctx context.Context, id uuid.UUID) (int64, error) {
+	db := conn(ctx, r.Db)
+	var count int64
+	err := db.Model(&models.Category{}).Where("parent_id = ?", id).Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *CategoryRepo) CountProducts(ctx context.Context, id uuid.UUID) (int64, error) {
+	db := conn(ctx, r.Db)
+	var count int64
+	err := db.Model(&models.Product{}).Where("category_id = ?", id).Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"context"
+	"gorm.io/gorm"
+)
+
+type txKey struct{}
+
+// conn returns the transaction carried by ctx, so that any repository called
+// inside Transaction joins it, or db when there is none.
+func conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
+
+// Transaction runs fn in a database transaction carried by the context passed
+// to fn. Calls nested inside an existing transaction reuse it.
+func Transaction(ctx context.Context, db *gorm.DB, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return fn(ctx)
+	}
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
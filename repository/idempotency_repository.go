@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"vigilant-spork/models"
+)
+
+type IdempotencyRepository interface {
+	CreateKey(ctx context.Context, record *models.IdempotencyKey) (bool, error)
+	GetKey(ctx context.Context, userID uuid.UUID, key string) (*models.IdempotencyKey, error)
+	ReclaimKey(ctx context.Context, record *models.IdempotencyKey, expiredBefore, abandonedBefore time.Time) (bool, error)
+	SaveResponse(ctx context.Context, record *models.IdempotencyKey) error
+	DeleteKey(ctx context.Context, userID uuid.UUID, key string) error
+}
+
+type IdempotencyRepo struct {
+	Db *gorm.DB
+}
+
+// CreateKey inserts the record unless the key is already taken for this user
+// and reports whether the insert happened.
+func (r *IdempotencyRepo) CreateKey(ctx context.Context, record *models.IdempotencyKey) (bool, error) {
+	db := conn(ctx, r.Db)
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *IdempotencyRepo) GetKey(ctx context.Context, userID uuid.UUID, key string) (*models.IdempotencyKey, error) {
+	db := conn(ctx, r.Db)
+	var record models.IdempotencyKey
+	err := db.Where("user_id = ? AND key = ?", userID, key).First(&record).Error
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// ReclaimKey hands an existing key to a new request when it expired before
+// expiredBefore, or was left in progress since abandonedBefore by a request
+// that never finished. It reports whether this caller won the key; of
+// several concurrent callers only one does.
+func (r *IdempotencyRepo) ReclaimKey(ctx context.Context, record *models.IdempotencyKey, expiredBefore,
+	abandonedBefore time.Time) (bool, error) {
+	db := conn(ctx, r.Db)
+	now := time.Now()
+	result := db.Model(&models.IdempotencyKey{}).
+		Where("user_id = ? AND key = ?", record.UserID, record.Key).
+		Where("created_at < ? OR (completed = false AND updated_at < ?)", expiredBefore, abandonedBefore).
+		Updates(map[string]interface{}{
+			"method":        record.Method,
+			"path":          record.Path,
+			"request_hash":  record.RequestHash,
+			"completed":     false,
+			"status_code":   0,
+			"content_type":  "",
+			"response_body": nil,
+			"created_at":    now,
+			"updated_at":    now,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *IdempotencyRepo) SaveResponse(ctx context.Context, record *models.IdempotencyKey) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(&models.IdempotencyKey{}).Where("user_id = ? AND key = ?", record.UserID, record.Key).
+		Updates(map[string]interface{}{
+			"completed":     true,
+			"status_code":   record.StatusCode,
+			"content_type":  record.ContentType,
+			"response_body": record.ResponseBody,
+		}).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *IdempotencyRepo) DeleteKey(ctx context.Context, userID uuid.UUID, key string) error {
+	db := conn(ctx, r.Db)
+	err := db.Where("user_id = ? AND key = ?", userID, key).Delete(&models.IdempotencyKey{}).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+	"vigilant-spork/models"
+)
+
+type LoginAttemptRepository interface {
+	GetAttempt(ctx context.Context, key string) (*models.LoginAttempt, error)
+	RecordFailure(ctx context.Context, key string, windowStart time.Time) (int, error)
+	SetLockedUntil(ctx context.Context, key string, lockedUntil time.Time) error
+	ResetAttempts(ctx context.Context, key string) error
+}
+
+type LoginAttemptRepo struct {
+	Db *gorm.DB
+}
+
+// GetAttempt returns nil when the key has no recorded failures.
+func (r *LoginAttemptRepo) GetAttempt(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	db := conn(ctx, r.Db)
+	var attempt models.LoginAttempt
+	err := db.Where("key = ?", key).First(&attempt).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+// RecordFailure atomically bumps the failure counter and returns the new
+// count. Counters whose last failure is older than windowStart start over.
+func (r *LoginAttemptRepo) RecordFailure(ctx context.Context, key string, windowStart time.Time) (int, error) {
+	db := conn(ctx, r.Db)
+	var failures int
+	err := db.Raw(`INSERT INTO login_attempts (key, failures, last_failure_at) VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at < ? THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure_at = EXCLUDED.last_failure_at
+		RETURNING failures`, key, time.Now(), windowStart).Scan(&failures).Error
+	if err != nil {
+		return 0, err
+	}
+	return failures, nil
+}
+
+func (r *LoginAttemptRepo) SetLockedUntil(ctx context.Context, key string, lockedUntil time.Time) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(&models.LoginAttempt{}).Where("key = ?", key).Update("locked_until", lockedUntil).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *LoginAttemptRepo) ResetAttempts(ctx context.Context, key string) error {
+	db := conn(ctx, r.Db)
+	err := db.Where("key = ?", key).Delete(&models.LoginAttempt{}).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
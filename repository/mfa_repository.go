@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"vigilant-spork/models"
+)
+
+type MFARepository interface {
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
+	GetMFA(ctx context.Context, userID uuid.UUID) (*models.UserMFA, error)
+	SaveMFA(ctx context.Context, mfa *models.UserMFA) error
+	ConfirmMFA(ctx context.Context, userID uuid.UUID) error
+	UseCounter(ctx context.Context, userID uuid.UUID, counter int64) (bool, error)
+	DeleteMFA(ctx context.Context, userID uuid.UUID) error
+	ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, hashes []string) error
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, hash string) (bool, error)
+}
+
+type MFARepo struct {
+	Db *gorm.DB
+}
+
+func (r *MFARepo) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return Transaction(ctx, r.Db, fn)
+}
+
+func (r *MFARepo) GetMFA(ctx context.Context, userID uuid.UUID) (*models.UserMFA, error) {
+	db := conn(ctx, r.Db)
+	var mfa models.UserMFA
+	err := db.Where("user_id = ?", userID).First(&mfa).Error
+	if err != nil {
+		return nil, err
+	}
+	return &mfa, nil
+}
+
+// SaveMFA stores a new, unconfirmed secret, replacing any previous one.
+func (r *MFARepo) SaveMFA(ctx context.Context, mfa *models.UserMFA) error {
+	db := conn(ctx, r.Db)
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"secret", "confirmed_at", "last_counter", "updated_at"}),
+	}).Create(mfa).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *MFARepo) ConfirmMFA(ctx context.Context, userID uuid.UUID) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(&models.UserMFA{}).Where("user_id = ?", userID).Update("confirmed_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UseCounter records counter as the last accepted time step. It reports false
+// when a code from that step or a later one was already accepted.
+func (r *MFARepo) UseCounter(ctx context.Context, userID uuid.UUID, counter int64) (bool, error) {
+	db := conn(ctx, r.Db)
+	result := db.Model(&models.UserMFA{}).
+		Where("user_id = ? AND last_counter < ?", userID, counter).
+		Update("last_counter", counter)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *MFARepo) DeleteMFA(ctx context.Context, userID uuid.UUID) error {
+	db := conn(ctx, r.Db)
+	err := db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	if err != nil {
+		return err
+	}
+	err = db.Where("user_id = ?", userID).Delete(&models.UserMFA{}).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *MFARepo) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, hashes []string) error {
+	db := conn(ctx, r.Db)
+	err := db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	if err != nil {
+		return err
+	}
+
+	var codes []models.RecoveryCode
+	for _, hash := range hashes {
+		codes = append(codes, models.RecoveryCode{UserID: userID, CodeHash: hash})
+	}
+	if len(codes) == 0 {
+		return nil
+	}
+	return db.Create(&codes).Error
+}
+
+func (r *MFARepo) UseRecoveryCode(ctx context.Context, userID uuid.UUID, hash string) (bool, error) {
+	db := conn(ctx, r.Db)
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
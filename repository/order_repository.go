@@ -6,54 +6,58 @@ import (
 	"github.com/gofrs/uuid"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
-	"vigilant-spork/db"
+	"time"
 	"vigilant-spork/models"
 )
 
 type OrderRepository interface {
-	Transaction(ctx context.Context, fn func(repo OrderRepository) error) error
+	Transaction(ctx context.Context, fn func(ctx context.Context, repo OrderRepository) error) error
 	GetCart(ctx context.Context, userID uuid.UUID) (*models.Cart, error)
 	VerifyAndDeductStock(ctx context.Context, cartItem *models.CartItem) error
-	CreateOrder(ctx context.Context, userID uuid.UUID) (*models.Order, error)
-	GetOrder(ctx context.Context, userID uuid.UUID) (*models.Order, error)
+	RestockProduct(ctx context.Context, productID uuid.UUID, quantity int) error
+	RestockVariant(ctx context.Context, variantID uuid.UUID, quantity int) error
+	MarkOrderRestocked(ctx context.Context, orderID uuid.UUID) (bool, error)
+	CreateOrder(ctx context.Context, userID uuid.UUID, shipping, billing models.OrderAddress) (*models.Order, error)
+	GetOrderByID(ctx context.Context, orderID uuid.UUID) (*models.Order, error)
+	LockOrder(ctx context.Context, orderID uuid.UUID) (*models.Order, error)
 	UpdateOrder(ctx context.Context, order *models.Order) error
+	UpdateOrderStatus(ctx context.Context, orderID uuid.UUID, from, to string) error
+	AddStatusHistory(ctx context.Context, entry *models.OrderStatusHistory) error
+	GetStatusHistory(ctx context.Context, orderID uuid.UUID) ([]models.OrderStatusHistory, error)
 	MoveCartItemsToOrder(ctx context.Context, orderID uuid.UUID, cartID uuid.UUID) error
 	ClearCart(ctx context.Context, cartID uuid.UUID) error
+	RestoreCartItems(ctx context.Context, userID, orderID uuid.UUID) error
 	GetOrderItems(ctx context.Context, orderID uuid.UUID) ([]models.OrderItem, error)
 	UpdateOrderTotal(ctx context.Context, total int64, orderID uuid.UUID) error
-	GetOrderHistory(userID uuid.UUID) ([]models.Order, error)
+	GetOrderHistory(ctx context.Context, userID uuid.UUID) ([]models.Order, error)
+	CreatePayment(ctx context.Context, payment *models.Payment) error
+	UpdatePayment(ctx context.Context, payment *models.Payment) error
+	GetPaymentByOrderID(ctx context.Context, orderID uuid.UUID) (*models.Payment, error)
+	GetPaymentByID(ctx context.Context, paymentID uuid.UUID) (*models.Payment, error)
+	GetPaymentByReference(ctx context.Context, provider, reference string) (*models.Payment, error)
+	ListUnsettledPayments(ctx context.Context, before time.Time) ([]models.Payment, error)
+	RecordPaymentEvent(ctx context.Context, event *models.PaymentEvent) (bool, error)
 }
 
 type OrderRepo struct {
 	Db *gorm.DB
 }
 
-var ErrInsufficientStock = errors.New("insufficient stock")
-
-func (r *OrderRepo) withTX(tx *gorm.DB) *OrderRepo {
-	return &OrderRepo{
-		Db: tx,
-	}
-}
-
-func (r *OrderRepo) Transaction(ctx context.Context, fn func(repo OrderRepository) error) error {
-	tx := r.Db.Begin()
-	if tx.Error != nil {
-		return tx.Error
-	}
-
-	repo := r.withTX(tx)
-	err := fn(repo)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+var (
+	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrStatusConflict    = errors.New("order status changed concurrently")
+)
 
-	return tx.Commit().Error
+// Transaction runs fn inside a database transaction. The context passed to fn
+// carries the transaction, so any repository called with it takes part.
+func (r *OrderRepo) Transaction(ctx context.Context, fn func(ctx context.Context, repo OrderRepository) error) error {
+	return Transaction(ctx, r.Db, func(ctx context.Context) error {
+		return fn(ctx, r)
+	})
 }
 
 func (r *OrderRepo) GetCart(ctx context.Context, userID uuid.UUID) (*models.Cart, error) {
-	db := r.Db.WithContext(ctx)
+	db := conn(ctx, r.Db)
 	var cart models.Cart
 	err := db.Preload("Items").Where("user_id = ?", userID).First(&cart).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -65,33 +69,92 @@ func (r *OrderRepo) GetCart(ctx context.Context, userID uuid.UUID) (*models.Cart
 	return &cart, nil
 }
 
+// VerifyAndDeductStock locks the cart item's variant, checks it has enough
+// stock and deducts the quantity from it and from its product's total.
 func (r *OrderRepo) VerifyAndDeductStock(ctx context.Context, cartItem *models.CartItem) error {
-	db := r.Db.WithContext(ctx)
-	var product models.Product
-	err := db.Model(&models.Product{}).Where("id = ?", cartItem.ProductID).Clauses(clause.Locking{Strength: "UPDATE"}).First(&product).Error
+	db := conn(ctx, r.Db)
+	var variant models.Variant
+	err := db.Model(&models.Variant{}).Where("id = ?", cartItem.VariantID).Clauses(clause.Locking{Strength: "UPDATE"}).First(&variant).Error
 	if err != nil {
 		return err
 	}
 
-	if cartItem.Quantity > product.StockQuantity {
+	if cartItem.Quantity > variant.StockQuantity {
 		return ErrInsufficientStock
 	}
 
-	product.StockQuantity = product.StockQuantity - cartItem.Quantity
+	err = db.Model(&models.Variant{}).Where("id = ?", variant.ID).
+		Update("stock_quantity", gorm.Expr("stock_quantity - ?", cartItem.Quantity)).Error
+	if err != nil {
+		return err
+	}
+
+	err = db.Model(&models.Product{}).Where("id = ?", variant.ProductID).
+		Update("stock_quantity", gorm.Expr("stock_quantity - ?", cartItem.Quantity)).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// RestockProduct returns stock to a product directly. It is only used for
+// order items that predate variants and whose variant no longer exists.
+func (r *OrderRepo) RestockProduct(ctx context.Context, productID uuid.UUID, quantity int) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(&models.Product{}).Where("id = ?", productID).
+		Update("stock_quantity", gorm.Expr("stock_quantity + ?", quantity)).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// RestockVariant returns stock to a variant and its product. It does nothing
+// if the variant has been deleted since.
+func (r *OrderRepo) RestockVariant(ctx context.Context, variantID uuid.UUID, quantity int) error {
+	db := conn(ctx, r.Db)
+	var variant models.Variant
+	err := db.Where("id = ?", variantID).Clauses(clause.Locking{Strength: "UPDATE"}).First(&variant).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = db.Model(&models.Variant{}).Where("id = ?", variantID).
+		Update("stock_quantity", gorm.Expr("stock_quantity + ?", quantity)).Error
+	if err != nil {
+		return err
+	}
 
-	err = db.Save(&product).Error
+	err = db.Model(&models.Product{}).Where("id = ?", variant.ProductID).
+		Update("stock_quantity", gorm.Expr("stock_quantity + ?", quantity)).Error
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *OrderRepo) CreateOrder(ctx context.Context, userID uuid.UUID) (*models.Order, error) {
-	db := r.Db.WithContext(ctx)
+// MarkOrderRestocked flags the order as restocked and reports whether this
+// call was the one that set the flag, so stock is only ever returned once.
+func (r *OrderRepo) MarkOrderRestocked(ctx context.Context, orderID uuid.UUID) (bool, error) {
+	db := conn(ctx, r.Db)
+	result := db.Model(&models.Order{}).Where("id = ? AND restocked_at IS NULL", orderID).Update("restocked_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *OrderRepo) CreateOrder(ctx context.Context, userID uuid.UUID, shipping, billing models.OrderAddress) (*models.Order, error) {
+	db := conn(ctx, r.Db)
 	var order = models.Order{
-		UserID: userID,
-		Total:  0,
-		Status: "PENDING",
+		UserID:          userID,
+		Total:           0,
+		Status:          models.OrderStatusPending,
+		ShippingAddress: shipping,
+		BillingAddress:  billing,
 	}
 	err := db.Create(&order).Error
 	if err != nil {
@@ -100,10 +163,21 @@ func (r *OrderRepo) CreateOrder(ctx context.Context, userID uuid.UUID) (*models.
 	return &order, nil
 }
 
-func (r *OrderRepo) GetOrder(ctx context.Context, userID uuid.UUID) (*models.Order, error) {
-	db := r.Db.WithContext(ctx)
+func (r *OrderRepo) GetOrderByID(ctx context.Context, orderID uuid.UUID) (*models.Order, error) {
+	db := conn(ctx, r.Db)
+	var order models.Order
+	err := db.Where("id = ?", orderID).First(&order).Error
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// LockOrder loads the order and locks it for the rest of the transaction.
+func (r *OrderRepo) LockOrder(ctx context.Context, orderID uuid.UUID) (*models.Order, error) {
+	db := conn(ctx, r.Db)
 	var order models.Order
-	err := db.Where("user_id = ?", userID).First(&order).Error
+	err := db.Where("id = ?", orderID).Clauses(clause.Locking{Strength: "UPDATE"}).First(&order).Error
 	if err != nil {
 		return nil, err
 	}
@@ -111,7 +185,7 @@ func (r *OrderRepo) GetOrder(ctx context.Context, userID uuid.UUID) (*models.Ord
 }
 
 func (r *OrderRepo) UpdateOrder(ctx context.Context, order *models.Order) error {
-	db := r.Db.WithContext(ctx)
+	db := conn(ctx, r.Db)
 	err := db.Where("id = ?", order.ID).Updates(order).Error
 	if err != nil {
 		return err
@@ -119,10 +193,42 @@ func (r *OrderRepo) UpdateOrder(ctx context.Context, order *models.Order) error
 	return nil
 }
 
+func (r *OrderRepo) UpdateOrderStatus(ctx context.Context, orderID uuid.UUID, from, to string) error {
+	db := conn(ctx, r.Db)
+	result := db.Model(&models.Order{}).Where("id = ? AND status = ?", orderID, from).Update("status", to)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrStatusConflict
+	}
+	return nil
+}
+
+func (r *OrderRepo) AddStatusHistory(ctx context.Context, entry *models.OrderStatusHistory) error {
+	db := conn(ctx, r.Db)
+	err := db.Create(entry).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *OrderRepo) GetStatusHistory(ctx context.Context, orderID uuid.UUID) ([]models.OrderStatusHistory, error) {
+	db := conn(ctx, r.Db)
+	var history []models.OrderStatusHistory
+	err := db.Where("order_id = ?", orderID).Order("created_at ASC").Find(&history).Error
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
 func (r *OrderRepo) MoveCartItemsToOrder(ctx context.Context, orderID uuid.UUID, cartID uuid.UUID) error {
-	db := r.Db.WithContext(ctx)
+	db := conn(ctx, r.Db)
 	var cartItems []models.CartItem
-	err := db.Where("cart_id = ?", cartID).First(&cartItems).Error
+	err := db.Preload("Product.Category").Preload("Product.Options").Preload("Variant.Options").
+		Where("cart_id = ?", cartID).Find(&cartItems).Error
 	if err != nil {
 		return err
 	}
@@ -140,11 +246,21 @@ func (r *OrderRepo) MoveCartItemsToOrder(ctx context.Context, orderID uuid.UUID,
 	var orderItems []models.OrderItem
 
 	for _, cartItem := range cartItems {
+		category := ""
+		if cartItem.Product.Category != nil {
+			category = cartItem.Product.Category.Name
+		}
+		variantID := cartItem.VariantID
 		orderItems = append(orderItems, models.OrderItem{
-			OrderID:   orderID,
-			ProductID: cartItem.ProductID,
-			Quantity:  cartItem.Quantity,
-			UnitPrice: cartItem.UnitPrice,
+			OrderID:         orderID,
+			ProductID:       cartItem.ProductID,
+			VariantID:       &variantID,
+			ProductName:     cartItem.Product.Name,
+			ProductCategory: category,
+			SKU:             cartItem.Variant.SKU,
+			VariantLabel:    cartItem.Product.VariantLabel(&cartItem.Variant),
+			Quantity:        cartItem.Quantity,
+			UnitPrice:       cartItem.UnitPrice,
 		})
 	}
 
@@ -157,7 +273,7 @@ func (r *OrderRepo) MoveCartItemsToOrder(ctx context.Context, orderID uuid.UUID,
 }
 
 func (r *OrderRepo) ClearCart(ctx context.Context, cartID uuid.UUID) error {
-	db := r.Db.WithContext(ctx)
+	db := conn(ctx, r.Db)
 	err := db.Where("cart_id = ?", cartID).Delete(&models.CartItem{}).Error
 	if err != nil {
 		return err
@@ -165,10 +281,60 @@ func (r *OrderRepo) ClearCart(ctx context.Context, cartID uuid.UUID) error {
 	return nil
 }
 
+// RestoreCartItems puts an order's lines back into the user's cart, adding to
+// anything put there since. Lines whose variant has been deleted are dropped.
+func (r *OrderRepo) RestoreCartItems(ctx context.Context, userID, orderID uuid.UUID) error {
+	db := conn(ctx, r.Db)
+	var cart models.Cart
+	err := db.Where("user_id = ?", userID).First(&cart).Error
+	if err != nil {
+		return err
+	}
+
+	items, err := r.GetOrderItems(ctx, orderID)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if item.VariantID == nil {
+			continue
+		}
+
+		var variants int64
+		err = db.Model(&models.Variant{}).Where("id = ?", *item.VariantID).Count(&variants).Error
+		if err != nil {
+			return err
+		}
+		if variants == 0 {
+			continue
+		}
+
+		var cartItem models.CartItem
+		err = db.Where("cart_id = ? AND variant_id = ?", cart.ID, *item.VariantID).First(&cartItem).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			cartItem = models.CartItem{
+				CartID:    cart.ID,
+				ProductID: item.ProductID,
+				VariantID: *item.VariantID,
+				Quantity:  item.Quantity,
+				UnitPrice: item.UnitPrice,
+			}
+			err = db.Omit(clause.Associations).Create(&cartItem).Error
+		} else if err == nil {
+			err = db.Model(&cartItem).Update("quantity", gorm.Expr("quantity + ?", item.Quantity)).Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (r *OrderRepo) GetOrderItems(ctx context.Context, orderID uuid.UUID) ([]models.OrderItem, error) {
-	db := r.Db.WithContext(ctx)
+	db := conn(ctx, r.Db)
 	var items []models.OrderItem
-	err := db.Where("order_id = ?", orderID).Find(&items).Error
+	err := db.Where("order_id = ?", orderID).Order("created_at ASC").Find(&items).Error
 	if err != nil {
 		return nil, err
 	}
@@ -176,7 +342,7 @@ func (r *OrderRepo) GetOrderItems(ctx context.Context, orderID uuid.UUID) ([]mod
 }
 
 func (r *OrderRepo) UpdateOrderTotal(ctx context.Context, total int64, orderID uuid.UUID) error {
-	db := r.Db.WithContext(ctx)
+	db := conn(ctx, r.Db)
 	err := db.Model(&models.Order{}).Where("id = ?", orderID).Update("total", total).Error
 	if err != nil {
 		return err
@@ -184,12 +350,91 @@ func (r *OrderRepo) UpdateOrderTotal(ctx context.Context, total int64, orderID u
 	return nil
 }
 
-func (r *OrderRepo) GetOrderHistory(userID uuid.UUID) ([]models.Order, error) {
+func (r *OrderRepo) GetOrderHistory(ctx context.Context, userID uuid.UUID) ([]models.Order, error) {
+	db := conn(ctx, r.Db)
 	var orders []models.Order
-	err := db.Db.Where("user_id = ?", userID).Order("created_at DESC").Find(&orders).Error
+	err := db.Where("user_id = ?", userID).Order("created_at DESC").Find(&orders).Error
 	if err != nil {
 		return nil, err
 	}
 
 	return orders, nil
 }
+
+func (r *OrderRepo) CreatePayment(ctx context.Context, payment *models.Payment) error {
+	db := conn(ctx, r.Db)
+	err := db.Create(payment).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *OrderRepo) UpdatePayment(ctx context.Context, payment *models.Payment) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(&models.Payment{}).Where("id = ?", payment.ID).
+		Updates(map[string]interface{}{
+			"provider_ref":   payment.ProviderRef,
+			"status":         payment.Status,
+			"failure_reason": payment.FailureReason,
+			"pending_status": payment.PendingStatus,
+		}).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *OrderRepo) GetPaymentByOrderID(ctx context.Context, orderID uuid.UUID) (*models.Payment, error) {
+	db := conn(ctx, r.Db)
+	var payment models.Payment
+	err := db.Where("order_id = ?", orderID).Order("created_at DESC").First(&payment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func (r *OrderRepo) GetPaymentByID(ctx context.Context, paymentID uuid.UUID) (*models.Payment, error) {
+	db := conn(ctx, r.Db)
+	var payment models.Payment
+	err := db.Where("id = ?", paymentID).First(&payment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func (r *OrderRepo) GetPaymentByReference(ctx context.Context, provider, reference string) (*models.Payment, error) {
+	db := conn(ctx, r.Db)
+	var payment models.Payment
+	err := db.Where("provider = ? AND provider_ref = ?", provider, reference).First(&payment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// ListUnsettledPayments returns payments last touched before the given time
+// that are still waiting for an authorization outcome or a settlement.
+func (r *OrderRepo) ListUnsettledPayments(ctx context.Context, before time.Time) ([]models.Payment, error) {
+	db := conn(ctx, r.Db)
+	var payments []models.Payment
+	err := db.Where("(status = ? OR pending_status <> '') AND updated_at < ?", models.PaymentStatusPending, before).
+		Order("updated_at ASC").Find(&payments).Error
+	if err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// RecordPaymentEvent stores the event and reports false if the provider has
+// already delivered an event with the same id.
+func (r *OrderRepo) RecordPaymentEvent(ctx context.Context, event *models.PaymentEvent) (bool, error) {
+	db := conn(ctx, r.Db)
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(event)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
@@ -1,86 +1,492 @@
 package repository
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gofrs/uuid"
 	"gorm.io/gorm"
-	"vigilant-spork/db"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+	"unicode"
 	"vigilant-spork/models"
+	"vigilant-spork/utils"
 )
 
 type ProductRepository interface {
-	AddProduct(product []models.Product) error
-	GetProductByID(id uuid.UUID) (*models.Product, error)
-	GetProductByName(name string) (*models.Product, error)
-	GetProducts(limit int, offset int, minPrice int, maxPrice int, category string) ([]models.Product, error)
-	GetProductsMetadata() (int64, error)
-	UpdateProduct(product *models.Product) (*models.Product, error)
-	DeleteProduct(id uuid.UUID) error
-	UpdateAggregates(productID uuid.UUID, avgRating float64, reviewCount int64) error
+	AddProduct(ctx context.Context, product []models.Product) error
+	GetProductByID(ctx context.Context, id uuid.UUID) (*models.Product, error)
+	GetProductByName(ctx context.Context, name string) (*models.Product, error)
+	GetProducts(ctx context.Context, limit int, offset int, filter ProductFilter, sort string, cursor string) ([]models.Product, error)
+	SuggestProducts(ctx context.Context, query string, limit int) ([]ProductSuggestion, error)
+	CountProducts(ctx context.Context, filter ProductFilter) (int64, error)
+	GetProductFacets(ctx context.Context, filter ProductFilter) (*ProductFacets, error)
+	UpdateProduct(ctx context.Context, product *models.Product) (*models.Product, error)
+	DeleteProduct(ctx context.Context, id uuid.UUID) error
+	UpdateAggregates(ctx context.Context, productID uuid.UUID, avgRating float64, reviewCount int64) error
 }
 
 type ProductRepo struct {
 	Db *gorm.DB
 }
 
-func (r *ProductRepo) AddProduct(products []models.Product) error {
-	for i := range products {
-		err := db.Db.Create(&products[i]).Error
-		if err != nil {
-			return err
-		}
+// ProductFilter narrows a product listing. Category is a category slug and
+// matches its subcategories too. Query is free text matched against the name
+// and description.
+type ProductFilter struct {
+	MinPrice  int
+	MaxPrice  int
+	Category  string
+	Query     string
+	MinRating int
+	InStock   bool
+}
+
+const (
+	SortRelevance = "relevance"
+	SortNewest    = "newest"
+	SortPriceAsc  = "price_asc"
+	SortPriceDesc = "price_desc"
+	SortRating    = "rating"
+	SortReviews   = "reviews"
+	SortName      = "name"
+)
+
+var (
+	ErrInvalidSort   = errors.New("unknown sort order")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// productSort orders a listing by column, with id as the tiebreak so that
+// every product has a unique position a cursor can point at. key reads the
+// column from a product and decode parses it back out of a cursor.
+type productSort struct {
+	column string
+	desc   bool
+	key    func(p *models.Product) interface{}
+	decode func(raw json.RawMessage) (interface{}, error)
+}
+
+func decodeInt(raw json.RawMessage) (interface{}, error) {
+	var v int64
+	err := json.Unmarshal(raw, &v)
+	return v, err
+}
+
+func decodeString(raw json.RawMessage) (interface{}, error) {
+	var v string
+	err := json.Unmarshal(raw, &v)
+	return v, err
+}
+
+func decodeTime(raw json.RawMessage) (interface{}, error) {
+	var v time.Time
+	err := json.Unmarshal(raw, &v)
+	return v, err
+}
+
+var productSorts = map[string]productSort{
+	SortNewest:    {"created_at", true, func(p *models.Product) interface{} { return p.CreatedAt }, decodeTime},
+	SortPriceAsc:  {"price_from", false, func(p *models.Product) interface{} { return p.PriceFrom }, decodeInt},
+	SortPriceDesc: {"price_from", true, func(p *models.Product) interface{} { return p.PriceFrom }, decodeInt},
+	SortRating:    {"rating", true, func(p *models.Product) interface{} { return int64(p.Rating) }, decodeInt},
+	SortReviews:   {"review_count", true, func(p *models.Product) interface{} { return p.ReviewCount }, decodeInt},
+	SortName:      {"name", false, func(p *models.Product) interface{} { return p.Name }, decodeString},
+}
+
+// ResolveProductSort validates a requested sort order and fills in the
+// default: relevance when there is a search query, newest otherwise.
+// Relevance without a query falls back to newest.
+func ResolveProductSort(sort, query string) (string, error) {
+	searching := prefixQuery(query, "") != ""
+	switch {
+	case sort == "" && searching, sort == SortRelevance && searching:
+		return SortRelevance, nil
+	case sort == "", sort == SortRelevance:
+		return SortNewest, nil
 	}
-	return nil
+	if _, ok := productSorts[sort]; !ok {
+		return "", ErrInvalidSort
+	}
+	return sort, nil
+}
+
+type productCursor struct {
+	Sort  string          `json:"s"`
+	Value json.RawMessage `json:"v"`
+	ID    uuid.UUID       `json:"id"`
+}
+
+// ProductCursor returns the opaque cursor for the page that starts after p.
+// Relevance-ranked listings have no cursor and return "".
+func ProductCursor(sort string, p *models.Product) (string, error) {
+	spec, ok := productSorts[sort]
+	if !ok {
+		return "", nil
+	}
+
+	value, err := json.Marshal(spec.key(p))
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(productCursor{Sort: sort, Value: value, ID: p.ID})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload), nil
+}
+
+// decodeProductCursor unpacks a cursor. A cursor made for a different sort
+// order points at a meaningless position and is rejected.
+func decodeProductCursor(sort, cursor string) (interface{}, uuid.UUID, error) {
+	spec, ok := productSorts[sort]
+	if !ok {
+		return nil, uuid.Nil, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, uuid.Nil, ErrInvalidCursor
+	}
+	var c productCursor
+	err = json.Unmarshal(payload, &c)
+	if err != nil || c.Sort != sort || c.ID == uuid.Nil {
+		return nil, uuid.Nil, ErrInvalidCursor
+	}
+
+	value, err := spec.decode(c.Value)
+	if err != nil {
+		return nil, uuid.Nil, ErrInvalidCursor
+	}
+	return value, c.ID, nil
+}
+
+// FacetValue is one option of a facet. Value is what to pass back as the
+// filter; Label, when set, is what to display.
+type FacetValue struct {
+	Value string `json:"value"`
+	Label string `json:"label,omitempty"`
+	Count int64  `json:"count"`
+}
+
+// PriceBucket counts products priced in [Min, Max), in cents. Max is nil for
+// the open-ended top bucket.
+type PriceBucket struct {
+	Min   int64  `json:"min"`
+	Max   *int64 `json:"max"`
+	Count int64  `json:"count"`
+}
+
+// RatingBucket counts products rated MinRating or higher.
+type RatingBucket struct {
+	MinRating int   `json:"min_rating"`
+	Count     int64 `json:"count"`
+}
+
+// ProductFacets summarises a listing for filter sidebars. Each facet is
+// counted with every filter applied except its own, so selecting a category
+// still shows how many products the other categories would have.
+type ProductFacets struct {
+	Categories []FacetValue   `json:"categories"`
+	Prices     []PriceBucket  `json:"prices"`
+	Ratings    []RatingBucket `json:"ratings"`
+	InStock    int64          `json:"in_stock"`
+}
+
+// priceBucketBounds are the lower bounds of the price facet buckets, in cents.
+var priceBucketBounds = []int64{0, 1000, 2500, 5000, 10000, 25000}
+
+const (
+	facetCategory = "category"
+	facetPrice    = "price"
+	facetRating   = "rating"
+	facetInStock  = "in_stock"
+)
+
+// applyFilter adds the filter's conditions to query, leaving out the facet
+// named by skip ("" applies them all).
+func applyFilter(query *gorm.DB, filter ProductFilter, skip string) *gorm.DB {
+	if skip != facetPrice {
+		query = query.Where("price_from BETWEEN ? AND ?", filter.MinPrice, filter.MaxPrice)
+	}
+	if skip != facetCategory && filter.Category != "" {
+		query = query.Where("category_id IN ("+descendantsSQL("slug")+")", utils.Slugify(filter.Category))
+	}
+	if skip != facetRating && filter.MinRating > 0 {
+		query = query.Where("rating >= ?", filter.MinRating)
+	}
+	if skip != facetInStock && filter.InStock {
+		query = query.Where("stock_quantity > 0")
+	}
+	if tsquery := prefixQuery(filter.Query, ""); tsquery != "" {
+		query = query.Where("search_vector @@ to_tsquery('english', ?)", tsquery)
+	}
+	return query
+}
+
+type ProductSuggestion struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
 }
 
-func (r *ProductRepo) GetProductByID(id uuid.UUID) (*models.Product, error) {
+// prefixQuery turns free text into a tsquery in which every word is a prefix
+// match, so "blu sho" finds "Blue Shoes". Possessives and everything but
+// letters and digits are dropped, which keeps user input from producing
+// tsquery syntax errors. It returns "" when no words are left.
+func prefixQuery(text string, weights string) string {
+	text = strings.ReplaceAll(strings.ToLower(text), "'s", "")
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = word + ":*" + weights
+	}
+	return strings.Join(words, " & ")
+}
+
+// rankOrder orders by relevance to tsquery, best first, breaking ties with
+// tiebreak. It is one expression because GORM drops an expression ORDER BY
+// when further Order calls are merged into it.
+func rankOrder(tsquery, tiebreak string) clause.OrderBy {
+	return clause.OrderBy{Expression: clause.Expr{
+		SQL:                "ts_rank(search_vector, to_tsquery('english', ?)) DESC, " + tiebreak,
+		Vars:               []interface{}{tsquery},
+		WithoutParentheses: true,
+	}}
+}
+
+// AddProduct creates each product with its default variant, which holds the
+// product's initial stock and sells at the product price.
+func (r *ProductRepo) AddProduct(ctx context.Context, products []models.Product) error {
+	return Transaction(ctx, r.Db, func(ctx context.Context) error {
+		db := conn(ctx, r.Db)
+		for i := range products {
+			products[i].PriceFrom = products[i].Price
+			err := db.Omit(clause.Associations).Create(&products[i]).Error
+			if err != nil {
+				return err
+			}
+
+			variant := models.Variant{
+				ProductID:     products[i].ID,
+				SKU:           models.DefaultSKU(products[i].ID),
+				StockQuantity: products[i].StockQuantity,
+			}
+			err = db.Create(&variant).Error
+			if err != nil {
+				return err
+			}
+			products[i].Variants = []models.Variant{variant}
+		}
+		return nil
+	})
+}
+
+func (r *ProductRepo) GetProductByID(ctx context.Context, id uuid.UUID) (*models.Product, error) {
+	db := conn(ctx, r.Db)
 	var product models.Product
-	err := db.Db.Preload("Reviews.User").First(&product, id).Error
+	err := db.Preload("Reviews.User").
+		Preload("Options", func(db *gorm.DB) *gorm.DB { return db.Order("position ASC") }).
+		Preload("Variants", func(db *gorm.DB) *gorm.DB { return db.Order("created_at ASC, id ASC") }).
+		Preload("Variants.Options").
+		First(&product, id).Error
 	if err != nil {
 		return nil, err
 	}
 	return &product, nil
 }
 
-func (r *ProductRepo) GetProductByName(name string) (*models.Product, error) {
+func (r *ProductRepo) GetProductByName(ctx context.Context, name string) (*models.Product, error) {
+	db := conn(ctx, r.Db)
 	var product models.Product
-	err := db.Db.Where("name = ?", name).First(&product).Error
+	err := db.Where("name = ?", name).First(&product).Error
 	if err != nil {
 		return nil, err
 	}
 	return &product, nil
 }
 
-func (r *ProductRepo) GetProducts(limit int, offset int, minPrice int, maxPrice int, category string) ([]models.Product, error) {
+// GetProducts lists products in the given (resolved) sort order. With a
+// cursor, the listing continues after the product it points at and offset
+// should be 0; keyset paging like this neither skips nor repeats products
+// when the catalogue changes between pages.
+func (r *ProductRepo) GetProducts(ctx context.Context, limit int, offset int, filter ProductFilter, sort string, cursor string) ([]models.Product, error) {
+	db := conn(ctx, r.Db)
 	var products []models.Product
-	query := db.Db.Where("price BETWEEN ? AND ?", minPrice, maxPrice)
+	query := applyFilter(db, filter, "")
+
+	if sort == SortRelevance {
+		if cursor != "" {
+			return nil, ErrInvalidCursor
+		}
+		query = query.Clauses(rankOrder(prefixQuery(filter.Query, ""), "id DESC"))
+	} else {
+		spec, ok := productSorts[sort]
+		if !ok {
+			return nil, ErrInvalidSort
+		}
 
-	if category != "" {
-		query = query.Where("LOWER(category) = LOWER(?)", category)
+		op, dir := ">", "ASC"
+		if spec.desc {
+			op, dir = "<", "DESC"
+		}
+
+		if cursor != "" {
+			value, id, err := decodeProductCursor(sort, cursor)
+			if err != nil {
+				return nil, err
+			}
+			query = query.Where(fmt.Sprintf("(%s, id) %s (?, ?)", spec.column, op), value, id)
+		}
+		query = query.Order(fmt.Sprintf("%s %s, id %s", spec.column, dir, dir))
 	}
-	err := query.Order("ID DESC").Limit(limit).Offset(offset).Find(&products).Error
+
+	err := query.Limit(limit).Offset(offset).Find(&products).Error
 	if err != nil {
 		return nil, err
 	}
 	return products, nil
 }
 
-func (r *ProductRepo) GetProductsMetadata() (int64, error) {
+// SuggestProducts finds products whose name contains a word starting with
+// each typed prefix, for autocomplete. The description is not matched.
+func (r *ProductRepo) SuggestProducts(ctx context.Context, query string, limit int) ([]ProductSuggestion, error) {
+	db := conn(ctx, r.Db)
+	suggestions := []ProductSuggestion{}
+
+	tsquery := prefixQuery(query, "A")
+	if tsquery == "" {
+		return suggestions, nil
+	}
+
+	err := db.Model(&models.Product{}).Select("id", "name").
+		Where("search_vector @@ to_tsquery('english', ?)", tsquery).
+		Clauses(rankOrder(tsquery, "name ASC")).
+		Limit(limit).Find(&suggestions).Error
+	if err != nil {
+		return nil, err
+	}
+	return suggestions, nil
+}
+
+func (r *ProductRepo) CountProducts(ctx context.Context, filter ProductFilter) (int64, error) {
+	db := conn(ctx, r.Db)
 	var totalItems int64
-	err := db.Db.Model(&models.Product{}).Count(&totalItems).Error
+	err := applyFilter(db.Model(&models.Product{}), filter, "").Count(&totalItems).Error
 	if err != nil {
 		return 0, err
 	}
 	return totalItems, nil
 }
 
-func (r *ProductRepo) UpdateProduct(product *models.Product) (*models.Product, error) {
-	err := db.Db.Model(&models.Product{}).Where("id = ?", product.ID).Updates(product).Error
+func (r *ProductRepo) GetProductFacets(ctx context.Context, filter ProductFilter) (*ProductFacets, error) {
+	db := conn(ctx, r.Db)
+	facets := &ProductFacets{
+		Categories: []FacetValue{},
+		Prices:     []PriceBucket{},
+		Ratings:    []RatingBucket{},
+	}
+
+	// a product counts towards its category and every category above it,
+	// matching the category filter, which includes subcategories
+	err := applyFilter(db.Model(&models.Product{}), filter, facetCategory).
+		Select("categories.slug AS value, categories.name AS label, COUNT(*) AS count").
+		Joins("JOIN (" + categoryClosureSQL + ") AS closure ON closure.descendant_id = products.category_id").
+		Joins("JOIN categories ON categories.id = closure.ancestor_id").
+		Group("categories.id").
+		Order("count DESC, label ASC").
+		Scan(&facets.Categories).Error
+	if err != nil {
+		return nil, err
+	}
+
+	bucketSQL := "CASE"
+	bucketVars := []interface{}{}
+	for i := len(priceBucketBounds) - 1; i > 0; i-- {
+		bucketSQL += " WHEN price_from >= ? THEN ?"
+		bucketVars = append(bucketVars, priceBucketBounds[i], i)
+	}
+	bucketSQL += " ELSE 0 END AS bucket, COUNT(*) AS count"
+
+	var priceRows []struct {
+		Bucket int
+		Count  int64
+	}
+	err = applyFilter(db.Model(&models.Product{}), filter, facetPrice).
+		Select(bucketSQL, bucketVars...).
+		Group("bucket").
+		Scan(&priceRows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	priceCounts := map[int]int64{}
+	for _, row := range priceRows {
+		priceCounts[row.Bucket] = row.Count
+	}
+	for i, min := range priceBucketBounds {
+		bucket := PriceBucket{Min: min, Count: priceCounts[i]}
+		if i+1 < len(priceBucketBounds) {
+			max := priceBucketBounds[i+1]
+			bucket.Max = &max
+		}
+		facets.Prices = append(facets.Prices, bucket)
+	}
+
+	var ratingRows []struct {
+		Rating int
+		Count  int64
+	}
+	err = applyFilter(db.Model(&models.Product{}), filter, facetRating).
+		Select("rating, COUNT(*) AS count").
+		Group("rating").
+		Scan(&ratingRows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for min := 4; min >= 1; min-- {
+		bucket := RatingBucket{MinRating: min}
+		for _, row := range ratingRows {
+			if row.Rating >= min {
+				bucket.Count += row.Count
+			}
+		}
+		facets.Ratings = append(facets.Ratings, bucket)
+	}
+
+	err = applyFilter(db.Model(&models.Product{}), filter, facetInStock).
+		Where("stock_quantity > 0").
+		Count(&facets.InStock).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return facets, nil
+}
+
+// UpdateProduct saves the product's own fields and then resyncs its stock
+// and price_from, which follows the base price. Review aggregates are left
+// alone: they are maintained by UpdateAggregates, and the copy in product
+// may be out of date.
+func (r *ProductRepo) UpdateProduct(ctx context.Context, product *models.Product) (*models.Product, error) {
+	db := conn(ctx, r.Db)
+	err := db.Model(&models.Product{}).Omit(clause.Associations, "stock_quantity", "price_from", "rating", "review_count").
+		Where("id = ?", product.ID).Updates(product).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = syncProduct(db, product.ID)
 	if err != nil {
 		return nil, err
 	}
 
 	var updatedProduct models.Product
-	err = db.Db.First(&updatedProduct, "id = ?", product.ID).Error
+	err = db.First(&updatedProduct, "id = ?", product.ID).Error
 	if err != nil {
 		return nil, err
 	}
@@ -88,17 +494,19 @@ func (r *ProductRepo) UpdateProduct(product *models.Product) (*models.Product, e
 	return &updatedProduct, nil
 }
 
-func (r *ProductRepo) DeleteProduct(id uuid.UUID) error {
+func (r *ProductRepo) DeleteProduct(ctx context.Context, id uuid.UUID) error {
+	db := conn(ctx, r.Db)
 	var product models.Product
-	err := db.Db.Where("id = ?", id).Delete(&product).Error
+	err := db.Where("id = ?", id).Delete(&product).Error
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *ProductRepo) UpdateAggregates(productID uuid.UUID, avgRating float64, reviewCount int64) error {
-	err := r.Db.Model(&models.Product{}).Where("id = ?", productID).
+func (r *ProductRepo) UpdateAggregates(ctx context.Context, productID uuid.UUID, avgRating float64, reviewCount int64) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(&models.Product{}).Where("id = ?", productID).
 		Updates(map[string]interface{}{
 			"rating":       avgRating,
 			"review_count": reviewCount,
@@ -1,20 +1,20 @@
 package repository
 
 import (
+	"context"
 	"github.com/gofrs/uuid"
 	"gorm.io/gorm"
-	"vigilant-spork/db"
 	"vigilant-spork/models"
 )
 
 type ReviewRepository interface {
-	CreateReview(review *models.Review) error
-	GetReviewsByProductID(productID uuid.UUID) ([]models.Review, error)
-	GetReviewByUserForProduct(userID, productID uuid.UUID) (*models.Review, error)
-	GetReviewByID(reviewID uuid.UUID) (*models.Review, error)
-	UpdateReview(review *models.Review) error
-	DeleteReview(id uuid.UUID) error
-	CalculateProductReviewAggregates(productID uuid.UUID) (avg float64, count int64, err error)
+	CreateReview(ctx context.Context, review *models.Review) error
+	GetReviewsByProductID(ctx context.Context, productID uuid.UUID) ([]models.Review, error)
+	GetReviewByUserForProduct(ctx context.Context, userID, productID uuid.UUID) (*models.Review, error)
+	GetReviewByID(ctx context.Context, reviewID uuid.UUID) (*models.Review, error)
+	UpdateReview(ctx context.Context, review *models.Review) error
+	DeleteReview(ctx context.Context, id uuid.UUID) error
+	CalculateProductReviewAggregates(ctx context.Context, productID uuid.UUID) (avg float64, count int64, err error)
 }
 
 type ReviewRepo struct {
@@ -25,63 +25,70 @@ func NewReviewRepository(db *gorm.DB) ReviewRepository {
 	return &ReviewRepo{Db: db}
 }
 
-func (r *ReviewRepo) CreateReview(review *models.Review) error {
-	err := db.Db.Create(review).Error
+func (r *ReviewRepo) CreateReview(ctx context.Context, review *models.Review) error {
+	db := conn(ctx, r.Db)
+	err := db.Create(review).Error
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *ReviewRepo) GetReviewsByProductID(productID uuid.UUID) ([]models.Review, error) {
+func (r *ReviewRepo) GetReviewsByProductID(ctx context.Context, productID uuid.UUID) ([]models.Review, error) {
+	db := conn(ctx, r.Db)
 	var reviews []models.Review
-	err := db.Db.Preload("User").Where("product_id = ?", productID).Find(&reviews).Error
+	err := db.Preload("User").Where("product_id = ?", productID).Find(&reviews).Error
 	if err != nil {
 		return nil, err
 	}
 	return reviews, nil
 }
 
-func (r *ReviewRepo) GetReviewByUserForProduct(userID, productID uuid.UUID) (*models.Review, error) {
+func (r *ReviewRepo) GetReviewByUserForProduct(ctx context.Context, userID, productID uuid.UUID) (*models.Review, error) {
+	db := conn(ctx, r.Db)
 	var review models.Review
-	err := db.Db.Where("user_id = ? AND product_id = ?", userID, productID).First(&review).Error
+	err := db.Where("user_id = ? AND product_id = ?", userID, productID).First(&review).Error
 	if err != nil {
 		return nil, err
 	}
 	return &review, nil
 }
 
-func (r *ReviewRepo) GetReviewByID(reviewID uuid.UUID) (*models.Review, error) {
+func (r *ReviewRepo) GetReviewByID(ctx context.Context, reviewID uuid.UUID) (*models.Review, error) {
+	db := conn(ctx, r.Db)
 	var review models.Review
-	err := db.Db.First(&review, "id = ?", reviewID).Error
+	err := db.First(&review, "id = ?", reviewID).Error
 	if err != nil {
 		return nil, err
 	}
 	return &review, nil
 }
 
-func (r *ReviewRepo) UpdateReview(review *models.Review) error {
-	err := db.Db.Save(review).Error
+func (r *ReviewRepo) UpdateReview(ctx context.Context, review *models.Review) error {
+	db := conn(ctx, r.Db)
+	err := db.Save(review).Error
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *ReviewRepo) DeleteReview(id uuid.UUID) error {
-	err := db.Db.Delete(&models.Review{}, id).Error
+func (r *ReviewRepo) DeleteReview(ctx context.Context, id uuid.UUID) error {
+	db := conn(ctx, r.Db)
+	err := db.Delete(&models.Review{}, id).Error
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *ReviewRepo) CalculateProductReviewAggregates(productID uuid.UUID) (float64, int64, error) {
+func (r *ReviewRepo) CalculateProductReviewAggregates(ctx context.Context, productID uuid.UUID) (float64, int64, error) {
+	db := conn(ctx, r.Db)
 	var result struct {
 		AvgRating   float64 `gorm:"column:avg_rating"`
 		ReviewCount int64   `gorm:"column:review_count"`
 	}
-	err := db.Db.Model(&models.Review{}).
+	err := db.Model(&models.Review{}).
 		Where("product_id = ?", productID).
 		Select("AVG(rating) AS avg_rating, COUNT(*) AS review_count").
 		Scan(&result).Error
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"vigilant-spork/models"
+)
+
+type RoleRepository interface {
+	GetRole(ctx context.Context, name string) (*models.Role, error)
+	ListRoles(ctx context.Context) ([]models.Role, error)
+	GetPermissions(ctx context.Context, role string) ([]string, error)
+	SaveRole(ctx context.Context, role *models.Role, permissions []string) error
+}
+
+type RoleRepo struct {
+	Db *gorm.DB
+}
+
+func (r *RoleRepo) GetRole(ctx context.Context, name string) (*models.Role, error) {
+	db := conn(ctx, r.Db)
+	var role models.Role
+	err := db.Preload("Permissions").Where("name = ?", name).First(&role).Error
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (r *RoleRepo) ListRoles(ctx context.Context) ([]models.Role, error) {
+	db := conn(ctx, r.Db)
+	var roles []models.Role
+	err := db.Preload("Permissions").Order("name").Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (r *RoleRepo) GetPermissions(ctx context.Context, role string) ([]string, error) {
+	db := conn(ctx, r.Db)
+	var permissions []string
+	err := db.Model(&models.RolePermission{}).Where("role = ?", role).Pluck("permission", &permissions).Error
+	if err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// SaveRole creates or updates the role and replaces its permissions.
+func (r *RoleRepo) SaveRole(ctx context.Context, role *models.Role, permissions []string) error {
+	return Transaction(ctx, r.Db, func(ctx context.Context) error {
+		db := conn(ctx, r.Db)
+		err := db.Omit("Permissions").Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"description", "updated_at"}),
+		}).Create(role).Error
+		if err != nil {
+			return err
+		}
+
+		err = db.Where("role = ?", role.Name).Delete(&models.RolePermission{}).Error
+		if err != nil {
+			return err
+		}
+
+		role.Permissions = nil
+		for _, p := range permissions {
+			role.Permissions = append(role.Permissions, models.RolePermission{Role: role.Name, Permission: p})
+		}
+		if len(role.Permissions) == 0 {
+			return nil
+		}
+		return db.Create(&role.Permissions).Error
+	})
+}
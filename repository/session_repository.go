@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"time"
+	"vigilant-spork/models"
+)
+
+type SessionRepository interface {
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
+	CreateSession(ctx context.Context, session *models.Session) error
+	GetSession(ctx context.Context, sessionID uuid.UUID) (*models.Session, error)
+	GetActiveSession(ctx context.Context, sessionID uuid.UUID) (*models.Session, error)
+	MarkSessionMFAVerified(ctx context.Context, sessionID uuid.UUID) error
+	ListActiveSessions(ctx context.Context, userID uuid.UUID) ([]models.Session, error)
+	TouchSession(ctx context.Context, sessionID uuid.UUID, expiresAt time.Time) error
+	RevokeSession(ctx context.Context, sessionID uuid.UUID) error
+	RevokeUserSessions(ctx context.Context, userID uuid.UUID) error
+	RevokeOtherSessions(ctx context.Context, userID, keepSessionID uuid.UUID) error
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error)
+	MarkRefreshTokenUsed(ctx context.Context, tokenID uuid.UUID) (bool, error)
+}
+
+type SessionRepo struct {
+	Db *gorm.DB
+}
+
+func (r *SessionRepo) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return Transaction(ctx, r.Db, fn)
+}
+
+func (r *SessionRepo) CreateSession(ctx context.Context, session *models.Session) error {
+	db := conn(ctx, r.Db)
+	err := db.Create(session).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *SessionRepo) GetSession(ctx context.Context, sessionID uuid.UUID) (*models.Session, error) {
+	db := conn(ctx, r.Db)
+	var session models.Session
+	err := db.Where("id = ?", sessionID).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetActiveSession returns gorm.ErrRecordNotFound for revoked or expired
+// sessions.
+func (r *SessionRepo) GetActiveSession(ctx context.Context, sessionID uuid.UUID) (*models.Session, error) {
+	db := conn(ctx, r.Db)
+	var session models.Session
+	err := db.Where("id = ? AND revoked_at IS NULL AND expires_at > ?", sessionID, time.Now()).
+		First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *SessionRepo) MarkSessionMFAVerified(ctx context.Context, sessionID uuid.UUID) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(&models.Session{}).Where("id = ?", sessionID).Update("mfa_verified", true).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *SessionRepo) ListActiveSessions(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	db := conn(ctx, r.Db)
+	var sessions []models.Session
+	err := db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (r *SessionRepo) TouchSession(ctx context.Context, sessionID uuid.UUID, expiresAt time.Time) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(&models.Session{}).Where("id = ?", sessionID).
+		Updates(map[string]interface{}{
+			"last_used_at": time.Now(),
+			"expires_at":   expiresAt,
+		}).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *SessionRepo) RevokeSession(ctx context.Context, sessionID uuid.UUID) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(&models.Session{}).Where("id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *SessionRepo) RevokeUserSessions(ctx context.Context, userID uuid.UUID) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *SessionRepo) RevokeOtherSessions(ctx context.Context, userID, keepSessionID uuid.UUID) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(&models.Session{}).Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keepSessionID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *SessionRepo) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	db := conn(ctx, r.Db)
+	err := db.Create(token).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *SessionRepo) GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	db := conn(ctx, r.Db)
+	var token models.RefreshToken
+	err := db.Where("token_hash = ?", hash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkRefreshTokenUsed consumes the token and reports false if it had already
+// been used, which means the token is being replayed.
+func (r *SessionRepo) MarkRefreshTokenUsed(ctx context.Context, tokenID uuid.UUID) (bool, error) {
+	db := conn(ctx, r.Db)
+	result := db.Model(&models.RefreshToken{}).Where("id = ? AND used_at IS NULL", tokenID).Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
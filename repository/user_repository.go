@@ -1,44 +1,273 @@
 package repository
 
 import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid"
 	"gorm.io/gorm"
-	"vigilant-spork/db"
+	"time"
 	"vigilant-spork/models"
 )
 
 type UserRepository interface {
-	GetUserByEmail(email string) (*models.User, error)
-	CreateUser(user *models.User) error
-	AddTokenToBlacklist(token string) error
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error)
+	CreateUser(ctx context.Context, user *models.User) error
+	ListUsers(ctx context.Context, page, limit int) ([]models.User, int64, error)
+	CountActiveUsersByRole(ctx context.Context, role string) (int64, error)
+	UpdateUserRole(ctx context.Context, userID uuid.UUID, role string) error
+	SetUserDisabled(ctx context.Context, userID uuid.UUID, disabledAt *time.Time) error
+	UpdatePassword(ctx context.Context, userID uuid.UUID, hash string) error
+	UpdateUserName(ctx context.Context, userID uuid.UUID, name string) error
+	UpdateUserEmail(ctx context.Context, userID uuid.UUID, email string) error
+	AnonymizeUser(ctx context.Context, userID uuid.UUID) error
+	CreateUserToken(ctx context.Context, token *models.UserToken) error
+	GetUserTokenByHash(ctx context.Context, purpose, hash string) (*models.UserToken, error)
+	MarkUserTokenUsed(ctx context.Context, tokenID uuid.UUID) (bool, error)
+	InvalidateUserTokens(ctx context.Context, userID uuid.UUID, purpose string) error
+	CountUserTokensSince(ctx context.Context, userID uuid.UUID, purpose string, since time.Time) (int64, error)
+	MarkEmailVerified(ctx context.Context, userID uuid.UUID) error
+	AddTokenToBlacklist(ctx context.Context, token string) error
+	IsTokenBlacklisted(ctx context.Context, token string) (bool, error)
 }
 
 type UserRepo struct {
 	Db *gorm.DB
 }
 
-func (r *UserRepo) GetUserByEmail(email string) (*models.User, error) {
+func (r *UserRepo) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return Transaction(ctx, r.Db, fn)
+}
+
+func (r *UserRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	db := conn(ctx, r.Db)
 	var user models.User
-	err := db.Db.Where("email = ?", email).First(&user).Error
+	err := db.Where("email = ?", email).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (r *UserRepo) CreateUser(user *models.User) error {
-	err := db.Db.Create(user).Error
+func (r *UserRepo) GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	db := conn(ctx, r.Db)
+	var user models.User
+	err := db.Where("id = ?", userID).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepo) CreateUser(ctx context.Context, user *models.User) error {
+	db := conn(ctx, r.Db)
+	err := db.Create(user).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *UserRepo) ListUsers(ctx context.Context, page, limit int) ([]models.User, int64, error) {
+	db := conn(ctx, r.Db)
+	var total int64
+	err := db.Model(&models.User{}).Where("deleted_at IS NULL").Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var users []models.User
+	err = db.Where("deleted_at IS NULL").Order("created_at").Offset((page - 1) * limit).Limit(limit).Find(&users).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (r *UserRepo) CountActiveUsersByRole(ctx context.Context, role string) (int64, error) {
+	db := conn(ctx, r.Db)
+	var count int64
+	err := db.Model(&models.User{}).Where("role = ? AND disabled_at IS NULL", role).Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *UserRepo) UpdateUserRole(ctx context.Context, userID uuid.UUID, role string) error {
+	db := conn(ctx, r.Db)
+	result := db.Model(&models.User{}).Where("id = ?", userID).Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *UserRepo) SetUserDisabled(ctx context.Context, userID uuid.UUID, disabledAt *time.Time) error {
+	db := conn(ctx, r.Db)
+	result := db.Model(&models.User{}).Where("id = ?", userID).Update("disabled_at", disabledAt)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *UserRepo) UpdatePassword(ctx context.Context, userID uuid.UUID, hash string) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(&models.User{}).Where("id = ?", userID).Update("password", hash).Error
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *UserRepo) AddTokenToBlacklist(token string) error {
+func (r *UserRepo) UpdateUserName(ctx context.Context, userID uuid.UUID, name string) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(&models.User{}).Where("id = ?", userID).Update("name", name).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateUserEmail changes the address and marks it verified, since it is
+// only called once the user confirmed the new address.
+func (r *UserRepo) UpdateUserEmail(ctx context.Context, userID uuid.UUID, email string) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(&models.User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{
+			"email":             email,
+			"email_verified_at": time.Now(),
+		}).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// AnonymizeUser deletes an account while keeping the row, because orders and
+// reviews still reference it. Personal data is scrubbed (the address book
+// too; orders keep their own address snapshots) and the account can no
+// longer log in.
+func (r *UserRepo) AnonymizeUser(ctx context.Context, userID uuid.UUID) error {
+	db := conn(ctx, r.Db)
+	now := time.Now()
+	err := db.Model(&models.User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{
+			"name":        "Deleted user",
+			"email":       "deleted-" + userID.String() + "@deleted.invalid",
+			"password":    "",
+			"disabled_at": now,
+			"deleted_at":  now,
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	err = db.Where("user_id = ?", userID).Delete(&models.UserToken{}).Error
+	if err != nil {
+		return err
+	}
+
+	err = db.Where("user_id = ?", userID).Delete(&models.Address{}).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *UserRepo) CreateUserToken(ctx context.Context, token *models.UserToken) error {
+	db := conn(ctx, r.Db)
+	err := db.Create(token).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *UserRepo) GetUserTokenByHash(ctx context.Context, purpose, hash string) (*models.UserToken, error) {
+	db := conn(ctx, r.Db)
+	var token models.UserToken
+	err := db.Where("purpose = ? AND token_hash = ?", purpose, hash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkUserTokenUsed reports false when the token had already been used, so
+// concurrent redemptions of the same token cannot both succeed.
+func (r *UserRepo) MarkUserTokenUsed(ctx context.Context, tokenID uuid.UUID) (bool, error) {
+	db := conn(ctx, r.Db)
+	result := db.Model(&models.UserToken{}).Where("id = ? AND used_at IS NULL", tokenID).Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *UserRepo) InvalidateUserTokens(ctx context.Context, userID uuid.UUID, purpose string) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *UserRepo) CountUserTokensSince(ctx context.Context, userID uuid.UUID, purpose string, since time.Time) (int64, error) {
+	db := conn(ctx, r.Db)
+	var count int64
+	err := db.Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND created_at > ?", userID, purpose, since).
+		Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *UserRepo) MarkEmailVerified(ctx context.Context, userID uuid.UUID) error {
+	db := conn(ctx, r.Db)
+	err := db.Model(&models.User{}).
+		Where("id = ? AND email_verified_at IS NULL", userID).
+		Update("email_verified_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *UserRepo) AddTokenToBlacklist(ctx context.Context, token string) error {
+	db := conn(ctx, r.Db)
 	var entry models.BlacklistedToken
 	entry.Token = token
-	err := db.Db.Create(&entry).Error
+	err := db.Create(&entry).Error
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+func (r *UserRepo) IsTokenBlacklisted(ctx context.Context, token string) (bool, error) {
+	db := conn(ctx, r.Db)
+	var entry models.BlacklistedToken
+	err := db.Where("token = ?", token).First(&entry).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
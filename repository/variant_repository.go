@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"vigilant-spork/models"
+)
+
+type VariantRepository interface {
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
+	LockProduct(ctx context.Context, productID uuid.UUID) error
+	ListOptions(ctx context.Context, productID uuid.UUID) ([]models.ProductOption, error)
+	CreateOption(ctx context.Context, option *models.ProductOption) error
+	DeleteOption(ctx context.Context, productID, optionID uuid.UUID) error
+	ListVariants(ctx context.Context, productID uuid.UUID) ([]models.Variant, error)
+	GetVariant(ctx context.Context, productID, variantID uuid.UUID) (*models.Variant, error)
+	LockVariant(ctx context.Context, productID, variantID uuid.UUID) (*models.Variant, error)
+	GetVariantBySKU(ctx context.Context, sku string) (*models.Variant, error)
+	CreateVariant(ctx context.Context, variant *models.Variant) error
+	UpdateVariant(ctx context.Context, variant *models.Variant, stock bool) error
+	DeleteVariant(ctx context.Context, productID, variantID uuid.UUID) error
+	SyncProduct(ctx context.Context, productID uuid.UUID) error
+}
+
+type VariantRepo struct {
+	Db *gorm.DB
+}
+
+func (r *VariantRepo) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return Transaction(ctx, r.Db, fn)
+}
+
+// LockProduct takes the product's row lock until the transaction ends. Option
+// and variant changes that check the product's other options or variants
+// (unique combinations, positions, the last variant) take it first, so two
+// of them cannot both pass their checks. It returns gorm.ErrRecordNotFound
+// for an unknown product.
+func (r *VariantRepo) LockProduct(ctx context.Context, productID uuid.UUID) error {
+	db := conn(ctx, r.Db)
+	var product models.Product
+	err := db.Select("id").Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", productID).First(&product).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *VariantRepo) ListOptions(ctx context.Context, productID uuid.UUID) ([]models.ProductOption, error) {
+	db := conn(ctx, r.Db)
+	var options []models.ProductOption
+	err := db.Where("product_id = ?", productID).Order("position ASC").Find(&options).Error
+	if err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+func (r *VariantRepo) CreateOption(ctx context.Context, option *models.ProductOption) error {
+	db := conn(ctx, r.Db)
+	err := db.Create(option).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteOption removes an option; its values go with it.
+func (r *VariantRepo) DeleteOption(ctx context.Context, productID, optionID uuid.UUID) error {
+	db := conn(ctx, r.Db)
+	result := db.Where("id = ? AND product_id = ?", optionID, productID).Delete(&models.ProductOption{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *VariantRepo) ListVariants(ctx context.Context, productID uuid.UUID) ([]models.Variant, error) {
+	db := conn(ctx, r.Db)
+	var variants []models.Variant
+	err := db.Preload("Options").Where("product_id = ?", productID).Order("created_at ASC, id ASC").Find(&variants).Error
+	if err != nil {
+		return nil, err
+	}
+	return variants, nil
+}
+
+func (r *VariantRepo) GetVariant(ctx context.Context, productID, variantID uuid.UUID) (*models.Variant, error) {
+	db := conn(ctx, r.Db)
+	var variant models.Variant
+	err := db.Preload("Options").Where("id = ? AND product_id = ?", variantID, productID).First(&variant).Error
+	if err != nil {
+		return nil, err
+	}
+	return &variant, nil
+}
+
+// LockVariant reads a variant with FOR UPDATE, so that checkout cannot deduct
+// stock between the read and an update based on it.
+func (r *VariantRepo) LockVariant(ctx context.Context, productID, variantID uuid.UUID) (*models.Variant, error) {
+	db := conn(ctx, r.Db)
+	var variant models.Variant
+	err := db.Preload("Options").Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND product_id = ?", variantID, productID).First(&variant).Error
+	if err != nil {
+		return nil, err
+	}
+	return &variant, nil
+}
+
+func (r *VariantRepo) GetVariantBySKU(ctx context.Context, sku string) (*models.Variant, error) {
+	db := conn(ctx, r.Db)
+	var variant models.Variant
+	err := db.Where("sku = ?", sku).First(&variant).Error
+	if err != nil {
+		return nil, err
+	}
+	return &variant, nil
+}
+
+// CreateVariant inserts the variant together with its option values.
+func (r *VariantRepo) CreateVariant(ctx context.Context, variant *models.Variant) error {
+	db := conn(ctx, r.Db)
+	err := db.Create(variant).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateVariant saves the SKU and price, and the stock only when stock is
+// set, so that an edit that leaves stock alone never writes back a count
+// checkout has changed since. Option values never change; a variant with
+// different values is a different variant.
+func (r *VariantRepo) UpdateVariant(ctx context.Context, variant *models.Variant, stock bool) error {
+	db := conn(ctx, r.Db)
+	columns := []interface{}{"price", "updated_at"}
+	if stock {
+		columns = append(columns, "stock_quantity")
+	}
+	err := db.Model(variant).Omit(clause.Associations).
+		Select("sku", columns...).
+		Updates(variant).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteVariant removes a variant and, through the foreign key, any cart
+// lines holding it. Orders keep their snapshot.
+func (r *VariantRepo) DeleteVariant(ctx context.Context, productID, variantID uuid.UUID) error {
+	db := conn(ctx, r.Db)
+	result := db.Where("id = ? AND product_id = ?", variantID, productID).Delete(&models.Variant{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// SyncProduct brings the product's stock and price_from back in step with
+// its variants.
+func (r *VariantRepo) SyncProduct(ctx context.Context, productID uuid.UUID) error {
+	return syncProduct(conn(ctx, r.Db), productID)
+}
+
+// syncProduct sets the product's stock to the total of its variants and its
+// price_from to the lowest price any of them sells at, falling back to the
+// product price for variants without an override.
+func syncProduct(db *gorm.DB, productID uuid.UUID) error {
+	err := db.Model(&models.Product{}).Where("id = ?", productID).Updates(map[string]interface{}{
+		"stock_quantity": gorm.Expr("(SELECT COALESCE(SUM(stock_quantity), 0) FROM variants WHERE product_id = products.id)"),
+		"price_from":     gorm.Expr("COALESCE((SELECT MIN(COALESCE(v.price, products.price)) FROM variants v WHERE v.product_id = products.id), products.price)"),
+	}).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
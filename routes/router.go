@@ -3,15 +3,21 @@ package routes
 import (
 	"github.com/gorilla/mux"
 	"net/http"
-	"os"
+	"vigilant-spork/config"
 	"vigilant-spork/handlers"
 	"vigilant-spork/middleware"
+	"vigilant-spork/models"
+	"vigilant-spork/repository"
 	"vigilant-spork/services"
 )
 
 func SetupRouter(
 	userHandler *handlers.UserHandler, productHandler *handlers.ProductHandler, cartHandler *handlers.CartHandler,
-	orderHandler *handlers.OrderHandler, reviewHandler *handlers.ReviewHandler, userService *services.UserService) *mux.Router {
+	orderHandler *handlers.OrderHandler, reviewHandler *handlers.ReviewHandler, webhookHandler *handlers.WebhookHandler,
+	healthHandler *handlers.HealthHandler, sessionHandler *handlers.SessionHandler, keysHandler *handlers.KeysHandler,
+	mfaHandler *handlers.MFAHandler, profileHandler *handlers.ProfileHandler, addressHandler *handlers.AddressHandler,
+	categoryHandler *handlers.CategoryHandler, variantHandler *handlers.VariantHandler,
+	userService *services.UserService, roleRepo repository.RoleRepository, idempotencyRepo repository.IdempotencyRepository, cfg *config.Config) *mux.Router {
 
 	r := mux.NewRouter().StrictSlash(true)
 
@@ -19,31 +25,90 @@ func SetupRouter(
 		w.Write([]byte("Welcome to FutureMarket by Vigilant-Spork!"))
 	})
 
+	// Probes
+	r.HandleFunc("/healthz", healthHandler.Liveness).Methods("GET")
+	r.HandleFunc("/readyz", healthHandler.Readiness).Methods("GET")
+	r.HandleFunc("/.well-known/jwks.json", keysHandler.JWKS).Methods("GET")
+
 	// Public Routes
 	r.HandleFunc("/api/v1/register", userHandler.Register).Methods("POST")
 	r.HandleFunc("/api/v1/login", userHandler.Login).Methods("POST")
+	r.HandleFunc("/api/v1/login/mfa", userHandler.LoginMFA).Methods("POST")
+	r.HandleFunc("/api/v1/token/refresh", sessionHandler.Refresh).Methods("POST")
+	r.HandleFunc("/api/v1/password/forgot", userHandler.ForgotPassword).Methods("POST")
+	r.HandleFunc("/api/v1/password/reset", userHandler.ResetPassword).Methods("POST")
+	r.HandleFunc("/api/v1/verify-email", userHandler.VerifyEmail).Methods("GET")
+	r.HandleFunc("/api/v1/me/email/confirm", profileHandler.ConfirmEmailChange).Methods("GET")
 	r.HandleFunc("/api/v1/products", productHandler.GetProducts).Methods("GET")
+	r.HandleFunc("/api/v1/products/suggest", productHandler.SuggestProducts).Methods("GET")
 	r.HandleFunc("/api/v1/products/{id}", productHandler.GetProductByID).Methods("GET")
+	r.HandleFunc("/api/v1/products/{id}/variants", variantHandler.GetVariants).Methods("GET")
 	r.HandleFunc("/api/v1/products/{product_id}/reviews", reviewHandler.GetReviews).Methods("GET")
+	r.HandleFunc("/api/v1/categories", categoryHandler.GetCategories).Methods("GET")
+	r.HandleFunc("/api/v1/webhooks/payments/{provider}", webhookHandler.HandlePaymentEvent).Methods("POST")
 
 	// Protected routes
-	secret := os.Getenv("JWT_SECRET")
 	protected := r.PathPrefix("/api/v1").Subrouter()
-	protected.Use(middleware.AuthMiddleware(secret))
+	protected.Use(middleware.AuthMiddleware(keysHandler.Keys, userService.UserRepo, sessionHandler.Service.SessionRepo, roleRepo,
+		cfg.RequireAdminMFA))
+	idempotent := middleware.Idempotency(idempotencyRepo)
+	catalogWrite := middleware.RequirePermission(models.PermissionCatalogWrite)
+	ordersFulfil := middleware.RequirePermission(models.PermissionOrdersFulfil)
+	usersManage := middleware.RequirePermission(models.PermissionUsersManage)
+	verified := middleware.RequireVerifiedEmail(cfg.RequireVerifiedEmail)
 
-	protected.HandleFunc("/products", productHandler.AddProduct).Methods("POST")
-	protected.HandleFunc("/products/{id}", productHandler.UpdateProduct).Methods("PATCH")
-	protected.HandleFunc("/products/{id}", productHandler.DeleteProduct).Methods("DELETE")
-	protected.HandleFunc("/cart/{product_id}", cartHandler.AddToCart).Methods("POST")
+	protected.Handle("/products", catalogWrite(idempotent(http.HandlerFunc(productHandler.AddProduct)))).Methods("POST")
+	protected.Handle("/products/{id}", catalogWrite(http.HandlerFunc(productHandler.UpdateProduct))).Methods("PATCH")
+	protected.Handle("/products/{id}", catalogWrite(http.HandlerFunc(productHandler.DeleteProduct))).Methods("DELETE")
+	protected.Handle("/products/{id}/options", catalogWrite(http.HandlerFunc(variantHandler.AddOption))).Methods("POST")
+	protected.Handle("/products/{id}/options/{option_id}", catalogWrite(http.HandlerFunc(variantHandler.DeleteOption))).Methods("DELETE")
+	protected.Handle("/products/{id}/variants", catalogWrite(http.HandlerFunc(variantHandler.CreateVariant))).Methods("POST")
+	protected.Handle("/products/{id}/variants/{variant_id}", catalogWrite(http.HandlerFunc(variantHandler.UpdateVariant))).Methods("PATCH")
+	protected.Handle("/products/{id}/variants/{variant_id}", catalogWrite(http.HandlerFunc(variantHandler.DeleteVariant))).Methods("DELETE")
+	protected.Handle("/categories", catalogWrite(http.HandlerFunc(categoryHandler.CreateCategory))).Methods("POST")
+	protected.Handle("/categories/{id}", catalogWrite(http.HandlerFunc(categoryHandler.UpdateCategory))).Methods("PUT")
+	protected.Handle("/categories/{id}", catalogWrite(http.HandlerFunc(categoryHandler.DeleteCategory))).Methods("DELETE")
+	protected.Handle("/cart/{product_id}", idempotent(http.HandlerFunc(cartHandler.AddToCart))).Methods("POST")
 	protected.HandleFunc("/cart", cartHandler.ViewCart).Methods("GET")
 	protected.HandleFunc("/cart/{product_id}", cartHandler.UpdateItemQuantity).Methods("PATCH")
 	protected.HandleFunc("/cart/{product_id}", cartHandler.RemoveItem).Methods("DELETE")
-	protected.HandleFunc("/checkout", orderHandler.MoveCartToOrder).Methods("POST")
+	protected.Handle("/checkout", verified(idempotent(http.HandlerFunc(orderHandler.MoveCartToOrder)))).Methods("POST")
 	protected.HandleFunc("/orders", orderHandler.GetOrderHistory).Methods("GET")
-	protected.HandleFunc("/products/{product_id}/reviews", reviewHandler.SubmitReview).Methods("POST")
-	protected.HandleFunc("/products/{product_id}/review/{review_id}", reviewHandler.UpdateReview).Methods("PATCH")
+	protected.HandleFunc("/orders/{id}", orderHandler.GetOrder).Methods("GET")
+	protected.Handle("/products/{product_id}/reviews", verified(http.HandlerFunc(reviewHandler.SubmitReview))).Methods("POST")
+	protected.Handle("/products/{product_id}/review/{review_id}", verified(http.HandlerFunc(reviewHandler.UpdateReview))).Methods("PATCH")
 	protected.HandleFunc("/products/{product_id}/review/{review_id}", reviewHandler.DeleteReview).Methods("DELETE")
 	protected.HandleFunc("/logout", userHandler.Logout).Methods("POST")
+	protected.HandleFunc("/verify-email/resend", userHandler.ResendVerification).Methods("POST")
+	protected.HandleFunc("/me", profileHandler.GetMe).Methods("GET")
+	protected.HandleFunc("/me", profileHandler.UpdateMe).Methods("PATCH")
+	protected.HandleFunc("/me", profileHandler.DeleteMe).Methods("DELETE")
+	protected.HandleFunc("/me/email", profileHandler.ChangeEmail).Methods("POST")
+	protected.HandleFunc("/me/password", profileHandler.ChangePassword).Methods("POST")
+	protected.HandleFunc("/me/addresses", addressHandler.ListAddresses).Methods("GET")
+	protected.HandleFunc("/me/addresses", addressHandler.CreateAddress).Methods("POST")
+	protected.HandleFunc("/me/addresses/{id}", addressHandler.GetAddress).Methods("GET")
+	protected.HandleFunc("/me/addresses/{id}", addressHandler.UpdateAddress).Methods("PUT")
+	protected.HandleFunc("/me/addresses/{id}", addressHandler.DeleteAddress).Methods("DELETE")
+	protected.HandleFunc("/me/addresses/{id}/default", addressHandler.SetDefaultAddress).Methods("POST")
+	protected.HandleFunc("/mfa/enroll", mfaHandler.Enroll).Methods("POST")
+	protected.HandleFunc("/mfa/confirm", mfaHandler.Confirm).Methods("POST")
+	protected.HandleFunc("/mfa/recovery-codes", mfaHandler.RegenerateRecoveryCodes).Methods("POST")
+	protected.HandleFunc("/mfa", mfaHandler.Disable).Methods("DELETE")
+	protected.HandleFunc("/sessions", sessionHandler.ListSessions).Methods("GET")
+	protected.HandleFunc("/sessions", sessionHandler.RevokeAllSessions).Methods("DELETE")
+	protected.HandleFunc("/sessions/{id}", sessionHandler.RevokeSession).Methods("DELETE")
+
+	// Admin routes
+	protected.Handle("/admin/orders/{id}/transitions", ordersFulfil(http.HandlerFunc(orderHandler.TransitionOrder))).Methods("POST")
+	protected.Handle("/admin/orders/{id}/history", ordersFulfil(http.HandlerFunc(orderHandler.GetStatusHistory))).Methods("GET")
+	protected.Handle("/admin/users", usersManage(http.HandlerFunc(userHandler.ListUsers))).Methods("GET")
+	protected.Handle("/admin/users/{id}/role", usersManage(http.HandlerFunc(userHandler.UpdateUserRole))).Methods("PATCH")
+	protected.Handle("/admin/users/{id}/disable", usersManage(http.HandlerFunc(userHandler.DisableUser))).Methods("POST")
+	protected.Handle("/admin/users/{id}/enable", usersManage(http.HandlerFunc(userHandler.EnableUser))).Methods("POST")
+	protected.Handle("/admin/users/{id}/unlock", usersManage(http.HandlerFunc(userHandler.UnlockUser))).Methods("POST")
+	protected.Handle("/admin/roles", usersManage(http.HandlerFunc(userHandler.ListRoles))).Methods("GET")
+	protected.Handle("/admin/roles/{name}", usersManage(http.HandlerFunc(userHandler.SaveRole))).Methods("PUT")
 
 	// helpful NotFound handler
 	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"strings"
+	"vigilant-spork/models"
+	"vigilant-spork/repository"
+)
+
+const maxAddresses = 20
+
+type AddressService struct {
+	AddressRepo repository.AddressRepository
+}
+
+var (
+	ErrAddressNotFound  = errors.New("address not found")
+	ErrInvalidAddress   = errors.New("full_name, line1, city, postal_code and a two-letter country code are required")
+	ErrTooManyAddresses = errors.New("address book is full")
+	ErrAddressRequired  = errors.New("a shipping address is required: add one to your address book or supply it at checkout")
+)
+
+// normalizeAddress trims every field and checks the ones a parcel cannot do
+// without. Country is stored as an upper-case ISO 3166 alpha-2 code.
+func normalizeAddress(address *models.Address) error {
+	fields := []*string{&address.Label, &address.FullName, &address.Line1, &address.Line2, &address.City,
+		&address.Region, &address.PostalCode, &address.Country, &address.Phone}
+	for _, f := range fields {
+		*f = strings.TrimSpace(*f)
+		if len(*f) > 200 {
+			return ErrInvalidAddress
+		}
+	}
+	address.Country = strings.ToUpper(address.Country)
+
+	if address.FullName == "" || address.Line1 == "" || address.City == "" || address.PostalCode == "" {
+		return ErrInvalidAddress
+	}
+	if len(address.Country) != 2 || strings.Trim(address.Country, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return ErrInvalidAddress
+	}
+	return nil
+}
+
+func (s *AddressService) ListAddresses(ctx context.Context, userID uuid.UUID) ([]models.Address, error) {
+	return s.AddressRepo.ListAddresses(ctx, userID)
+}
+
+func (s *AddressService) GetAddress(ctx context.Context, userID, addressID uuid.UUID) (*models.Address, error) {
+	address, err := s.AddressRepo.GetAddress(ctx, userID, addressID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAddressNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return address, nil
+}
+
+// CreateAddress adds an address to the user's book. The first address always
+// becomes the default.
+func (s *AddressService) CreateAddress(ctx context.Context, userID uuid.UUID, address *models.Address) error {
+	err := normalizeAddress(address)
+	if err != nil {
+		return err
+	}
+	address.ID = uuid.Nil
+	address.UserID = userID
+
+	return s.AddressRepo.Transaction(ctx, func(ctx context.Context) error {
+		existing, err := s.AddressRepo.ListAddresses(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if len(existing) >= maxAddresses {
+			return ErrTooManyAddresses
+		}
+
+		if len(existing) == 0 {
+			address.IsDefault = true
+		}
+		if address.IsDefault {
+			err = s.AddressRepo.ClearDefaultAddress(ctx, userID)
+			if err != nil {
+				return err
+			}
+		}
+		return s.AddressRepo.CreateAddress(ctx, address)
+	})
+}
+
+// UpdateAddress replaces the fields of an address. It does not change which
+// address is the default; see SetDefaultAddress.
+func (s *AddressService) UpdateAddress(ctx context.Context, userID, addressID uuid.UUID, changes *models.Address) (*models.Address, error) {
+	address, err := s.GetAddress(ctx, userID, addressID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = normalizeAddress(changes)
+	if err != nil {
+		return nil, err
+	}
+	changes.ID = address.ID
+	changes.UserID = userID
+	changes.IsDefault = address.IsDefault
+	changes.CreatedAt = address.CreatedAt
+
+	err = s.AddressRepo.UpdateAddress(ctx, changes)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetAddress(ctx, userID, addressID)
+}
+
+func (s *AddressService) SetDefaultAddress(ctx context.Context, userID, addressID uuid.UUID) error {
+	err := s.AddressRepo.Transaction(ctx, func(ctx context.Context) error {
+		err := s.AddressRepo.ClearDefaultAddress(ctx, userID)
+		if err != nil {
+			return err
+		}
+		return s.AddressRepo.SetDefaultAddress(ctx, userID, addressID)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrAddressNotFound
+	}
+	return err
+}
+
+// DeleteAddress removes an address. When it was the default, the oldest
+// remaining address takes over. Orders are unaffected: they keep snapshots.
+func (s *AddressService) DeleteAddress(ctx context.Context, userID, addressID uuid.UUID) error {
+	err := s.AddressRepo.Transaction(ctx, func(ctx context.Context) error {
+		address, err := s.AddressRepo.GetAddress(ctx, userID, addressID)
+		if err != nil {
+			return err
+		}
+
+		err = s.AddressRepo.DeleteAddress(ctx, userID, addressID)
+		if err != nil {
+			return err
+		}
+		if !address.IsDefault {
+			return nil
+		}
+
+		remaining, err := s.AddressRepo.ListAddresses(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if len(remaining) == 0 {
+			return nil
+		}
+		return s.AddressRepo.SetDefaultAddress(ctx, userID, remaining[0].ID)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrAddressNotFound
+	}
+	return err
+}
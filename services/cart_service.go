@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/gofrs/uuid"
@@ -12,10 +13,13 @@ import (
 type CartService struct {
 	CartRepo    repository.CartRepository
 	ProductRepo repository.ProductRepository
+	VariantRepo repository.VariantRepository
 }
 
-func (s *CartService) AddToCart(userID, productID uuid.UUID) error {
-	cart, err := s.CartRepo.GetOrCreateCart(userID)
+// AddToCart adds one of a product's variants to the user's cart. variantID
+// may be nil for a product with a single variant.
+func (s *CartService) AddToCart(ctx context.Context, userID, productID uuid.UUID, variantID *uuid.UUID) error {
+	cart, err := s.CartRepo.GetOrCreateCart(ctx, userID)
 	if err != nil {
 		return err
 	}
@@ -24,20 +28,17 @@ func (s *CartService) AddToCart(userID, productID uuid.UUID) error {
 		return fmt.Errorf("cart not found or could not be created for user")
 	}
 
-	product, err := s.ProductRepo.GetProductByID(productID)
+	variant, err := resolveVariant(ctx, s.VariantRepo, productID, variantID)
 	if err != nil {
 		return err
 	}
-	if product == nil {
-		return fmt.Errorf("product not found")
-	}
 
-	err = s.CartRepo.AddItemToCart(productID, cart.ID)
+	err = s.CartRepo.AddItemToCart(ctx, variant.ID, cart.ID)
 	if err != nil {
 		return err
 	}
 
-	items, err := s.CartRepo.GetCartItems(cart.ID)
+	items, err := s.CartRepo.GetCartItems(ctx, cart.ID)
 	if err != nil {
 		return err
 	}
@@ -51,15 +52,15 @@ func (s *CartService) AddToCart(userID, productID uuid.UUID) error {
 		total += int64(item.Quantity) * item.UnitPrice
 	}
 
-	err = s.CartRepo.UpdateCartTotal(total, cart.ID)
+	err = s.CartRepo.UpdateCartTotal(ctx, total, cart.ID)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (s *CartService) ViewCart(userID uuid.UUID) (*models.Cart, error) {
-	cart, err := s.CartRepo.GetCartByUserID(userID)
+func (s *CartService) ViewCart(ctx context.Context, userID uuid.UUID) (*models.Cart, error) {
+	cart, err := s.CartRepo.GetCartByUserID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -67,7 +68,7 @@ func (s *CartService) ViewCart(userID uuid.UUID) (*models.Cart, error) {
 	var total int64
 	for i := range cart.Items {
 		item := &cart.Items[i]
-		item.UnitPrice = item.Product.Price
+		item.UnitPrice = item.Variant.UnitPrice(item.Product.Price)
 		total += int64(item.Quantity) * item.UnitPrice
 	}
 
@@ -76,21 +77,32 @@ func (s *CartService) ViewCart(userID uuid.UUID) (*models.Cart, error) {
 	return cart, nil
 }
 
-func (s *CartService) UpdateItemQuantity(userID, productID uuid.UUID, quantity int) (*models.CartItem, error) {
-	cartItem, err := s.CartRepo.UpdateItemQuantity(userID, productID, quantity)
+func (s *CartService) UpdateItemQuantity(ctx context.Context, userID, productID uuid.UUID, variantID *uuid.UUID,
+	quantity int) (*models.CartItem, error) {
+	variant, err := resolveVariant(ctx, s.VariantRepo, productID, variantID)
+	if err != nil {
+		return nil, err
+	}
+
+	cartItem, err := s.CartRepo.UpdateItemQuantity(ctx, userID, variant.ID, quantity)
 	if err != nil {
 		return nil, err
 	}
 	return cartItem, nil
 }
 
-func (s *CartService) RemoveItem(userID, productID uuid.UUID) error {
-	cart, err := s.CartRepo.GetOrCreateCart(userID)
+func (s *CartService) RemoveItem(ctx context.Context, userID, productID uuid.UUID, variantID *uuid.UUID) error {
+	cart, err := s.CartRepo.GetOrCreateCart(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	variant, err := resolveVariant(ctx, s.VariantRepo, productID, variantID)
 	if err != nil {
 		return err
 	}
 
-	err = s.CartRepo.RemoveItemFromCart(cart.ID, productID)
+	err = s.CartRepo.RemoveItemFromCart(ctx, cart.ID, variant.ID)
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return gorm.ErrRecordNotFound
 	}
@@ -98,7 +110,7 @@ func (s *CartService) RemoveItem(userID, productID uuid.UUID) error {
 		return err
 	}
 
-	items, err := s.CartRepo.GetCartItems(cart.ID)
+	items, err := s.CartRepo.GetCartItems(ctx, cart.ID)
 	if err != nil {
 		return err
 	}
@@ -110,5 +122,5 @@ func (s *CartService) RemoveItem(userID, productID uuid.UUID) error {
 
 	total = total / 100
 
-	return s.CartRepo.UpdateCartTotal(total, cart.ID)
+	return s.CartRepo.UpdateCartTotal(ctx, total, cart.ID)
 }
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"regexp"
+	"strings"
+	"vigilant-spork/models"
+	"vigilant-spork/repository"
+	"vigilant-spork/utils"
+)
+
+type CategoryService struct {
+	CategoryRepo repository.CategoryRepository
+}
+
+var (
+	ErrCategoryNotFound = errors.New("category not found")
+	ErrUnknownCategory  = errors.New("unknown category")
+	ErrInvalidCategory  = errors.New("category name must be between 1 and 100 characters")
+	ErrInvalidSlug      = errors.New("slug must be lowercase letters and digits separated by single hyphens")
+	ErrSlugTaken        = errors.New("another category already uses that slug")
+	ErrCategoryCycle    = errors.New("a category cannot be moved under itself or one of its subcategories")
+	ErrCategoryNotEmpty = errors.New("category still has subcategories or products")
+	ErrUnknownParent    = errors.New("parent category not found")
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// CategoryTree returns the root categories with their subcategories nested
+// under Children, each level sorted by name.
+func (s *CategoryService) CategoryTree(ctx context.Context) ([]models.Category, error) {
+	categories, err := s.CategoryRepo.ListCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	children := map[uuid.UUID][]models.Category{}
+	var roots []models.Category
+	for _, c := range categories {
+		if c.ParentID == nil {
+			roots = append(roots, c)
+			continue
+		}
+		children[*c.ParentID] = append(children[*c.ParentID], c)
+	}
+
+	var attach func(nodes []models.Category) []models.Category
+	attach = func(nodes []models.Category) []models.Category {
+		for i := range nodes {
+			nodes[i].Children = attach(children[nodes[i].ID])
+		}
+		return nodes
+	}
+
+	tree := attach(roots)
+	if tree == nil {
+		tree = []models.Category{}
+	}
+	return tree, nil
+}
+
+func (s *CategoryService) GetCategory(ctx context.Context, id uuid.UUID) (*models.Category, error) {
+	category, err := s.CategoryRepo.GetCategory(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCategoryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return category, nil
+}
+
+// CreateCategory adds a category. An empty slug is derived from the name.
+func (s *CategoryService) CreateCategory(ctx context.Context, name, slug string, parentID *uuid.UUID) (*models.Category, error) {
+	category := &models.Category{ParentID: parentID}
+	err := s.prepare(ctx, category, name, slug)
+	if err != nil {
+		return nil, err
+	}
+
+	if parentID != nil {
+		_, err = s.CategoryRepo.GetCategory(ctx, *parentID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUnknownParent
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.CategoryRepo.CreateCategory(ctx, category)
+	if err != nil {
+		return nil, err
+	}
+	return category, nil
+}
+
+// UpdateCategory renames a category and/or moves it. A nil parentID moves it
+// to the root. Products keep their category, so they move with it.
+func (s *CategoryService) UpdateCategory(ctx context.Context, id uuid.UUID, name, slug string, parentID *uuid.UUID) (*models.Category, error) {
+	var category *models.Category
+	err := s.CategoryRepo.Transaction(ctx, func(ctx context.Context) error {
+		// the cycle check below is only valid while no other move can run
+		err := s.CategoryRepo.LockTree(ctx)
+		if err != nil {
+			return err
+		}
+
+		category, err = s.GetCategory(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		err = s.prepare(ctx, category, name, slug)
+		if err != nil {
+			return err
+		}
+
+		if parentID != nil {
+			_, err = s.CategoryRepo.GetCategory(ctx, *parentID)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUnknownParent
+			}
+			if err != nil {
+				return err
+			}
+
+			subtree, err := s.CategoryRepo.GetDescendantIDs(ctx, id)
+			if err != nil {
+				return err
+			}
+			for _, descendant := range subtree {
+				if descendant == *parentID {
+					return ErrCategoryCycle
+				}
+			}
+		}
+		category.ParentID = parentID
+
+		return s.CategoryRepo.UpdateCategory(ctx, category)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return category, nil
+}
+
+// DeleteCategory removes an empty category. Categories with subcategories or
+// products must be emptied first, so nothing is orphaned by accident.
+func (s *CategoryService) DeleteCategory(ctx context.Context, id uuid.UUID) error {
+	_, err := s.GetCategory(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	children, err := s.CategoryRepo.CountChildren(ctx, id)
+	if err != nil {
+		return err
+	}
+	products, err := s.CategoryRepo.CountProducts(ctx, id)
+	if err != nil {
+		return err
+	}
+	if children > 0 || products > 0 {
+		return ErrCategoryNotEmpty
+	}
+
+	return s.CategoryRepo.DeleteCategory(ctx, id)
+}
+
+// prepare validates and sets the name and slug, checking the slug is not used
+// by another category.
+func (s *CategoryService) prepare(ctx context.Context, category *models.Category, name, slug string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return ErrInvalidCategory
+	}
+
+	slug = strings.TrimSpace(slug)
+	if slug == "" {
+		slug = utils.Slugify(name)
+	}
+	if !slugPattern.MatchString(slug) || len(slug) > 100 {
+		return ErrInvalidSlug
+	}
+
+	existing, err := s.CategoryRepo.GetCategoryBySlug(ctx, slug)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if existing != nil && existing.ID != category.ID {
+		return ErrSlugTaken
+	}
+
+	category.Name = name
+	category.Slug = slug
+	return nil
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"vigilant-spork/repository"
+)
+
+const (
+	loginBackoffBase = time.Second
+	// failures older than this no longer count towards a lockout
+	loginFailureWindow = 24 * time.Hour
+)
+
+var ErrTooManyAttempts = errors.New("too many failed login attempts, try again later")
+
+// LockedOutError is returned while a login is blocked. It matches
+// ErrTooManyAttempts with errors.Is.
+type LockedOutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedOutError) Error() string {
+	return ErrTooManyAttempts.Error()
+}
+
+func (e *LockedOutError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
+// LoginGuard throttles password guessing per account and per client address.
+// The first few failures are free; after that every failure locks the key for
+// twice as long as the previous one, up to MaxLockout. Counters live in the
+// database so every instance sees the same limits.
+type LoginGuard struct {
+	Attempts            repository.LoginAttemptRepository
+	AccountFreeAttempts int
+	IPFreeAttempts      int
+	MaxLockout          time.Duration
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns a *LockedOutError if the account or the address is locked.
+func (g *LoginGuard) Check(ctx context.Context, email, ip string) error {
+	var retryAfter time.Duration
+	for _, key := range g.keys(email, ip) {
+		attempt, err := g.Attempts.GetAttempt(ctx, key)
+		if err != nil {
+			return err
+		}
+		if attempt == nil || attempt.LockedUntil == nil {
+			continue
+		}
+		if wait := time.Until(*attempt.LockedUntil); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+
+	if retryAfter > 0 {
+		return &LockedOutError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+func (g *LoginGuard) Failure(ctx context.Context, email, ip string) error {
+	now := time.Now()
+	for _, key := range g.keys(email, ip) {
+		failures, err := g.Attempts.RecordFailure(ctx, key, now.Add(-loginFailureWindow))
+		if err != nil {
+			return err
+		}
+
+		free := g.AccountFreeAttempts
+		if strings.HasPrefix(key, "ip:") {
+			free = g.IPFreeAttempts
+		}
+
+		lockout := g.lockout(failures, free)
+		if lockout > 0 {
+			err = g.Attempts.SetLockedUntil(ctx, key, now.Add(lockout))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Success clears the account counter. The address counter is left alone so
+// an attacker cannot reset it by logging into an account of their own.
+func (g *LoginGuard) Success(ctx context.Context, email string) error {
+	return g.Attempts.ResetAttempts(ctx, accountKey(email))
+}
+
+func (g *LoginGuard) Unlock(ctx context.Context, email string) error {
+	return g.Attempts.ResetAttempts(ctx, accountKey(email))
+}
+
+func (g *LoginGuard) keys(email, ip string) []string {
+	keys := []string{accountKey(email)}
+	if ip != "" {
+		keys = append(keys, ipKey(ip))
+	}
+	return keys
+}
+
+func (g *LoginGuard) lockout(failures, free int) time.Duration {
+	if failures <= free {
+		return 0
+	}
+	shift := failures - free - 1
+	if shift > 30 {
+		return g.MaxLockout
+	}
+	lockout := loginBackoffBase << shift
+	if lockout > g.MaxLockout {
+		return g.MaxLockout
+	}
+	return lockout
+}
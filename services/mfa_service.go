@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+	"vigilant-spork/models"
+	"vigilant-spork/repository"
+	"vigilant-spork/totp"
+	"vigilant-spork/utils"
+)
+
+const recoveryCodeCount = 10
+
+type MFAService struct {
+	MFARepo         repository.MFARepository
+	UserRepo        repository.UserRepository
+	SessionRepo     repository.SessionRepository
+	RoleRepo        repository.RoleRepository
+	Issuer          string
+	RequireAdminMFA bool
+}
+
+type MFAEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnrolled    = errors.New("two-factor authentication is not enabled")
+	ErrInvalidMFACode    = errors.New("invalid authentication code")
+	ErrMFARequired       = errors.New("two-factor authentication is mandatory for this account")
+)
+
+// Enroll starts (or restarts) enrolment with a fresh secret. The secret only
+// protects logins after Confirm.
+func (s *MFAService) Enroll(ctx context.Context, userID uuid.UUID) (*MFAEnrollment, error) {
+	user, err := s.UserRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.MFARepo.GetMFA(ctx, userID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if existing != nil && existing.ConfirmedAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.MFARepo.SaveMFA(ctx, &models.UserMFA{UserID: userID, Secret: secret})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MFAEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(s.Issuer, user.Email, secret),
+	}, nil
+}
+
+// Confirm enables MFA once the user shows a code from their app, marks the
+// current session as verified and returns the recovery codes. This is the
+// only time the codes are shown.
+func (s *MFAService) Confirm(ctx context.Context, userID, sessionID uuid.UUID, code string) ([]string, error) {
+	var codes []string
+	err := s.MFARepo.Transaction(ctx, func(ctx context.Context) error {
+		mfa, err := s.MFARepo.GetMFA(ctx, userID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrMFANotEnrolled
+		}
+		if err != nil {
+			return err
+		}
+		if mfa.ConfirmedAt != nil {
+			return ErrMFAAlreadyEnabled
+		}
+
+		err = s.checkTOTP(ctx, mfa, code)
+		if err != nil {
+			return err
+		}
+
+		err = s.MFARepo.ConfirmMFA(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		codes, err = s.replaceRecoveryCodes(ctx, userID)
+		if err != nil {
+			return err
+		}
+		return s.SessionRepo.MarkSessionMFAVerified(ctx, sessionID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *MFAService) Disable(ctx context.Context, userID uuid.UUID, code string) error {
+	user, err := s.UserRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if s.RequireAdminMFA {
+		// any role that grants permissions must keep its second factor
+		permissions, err := s.RoleRepo.GetPermissions(ctx, user.Role)
+		if err != nil {
+			return err
+		}
+		if len(permissions) > 0 {
+			return ErrMFARequired
+		}
+	}
+
+	return s.MFARepo.Transaction(ctx, func(ctx context.Context) error {
+		err := s.VerifyCode(ctx, userID, code)
+		if err != nil {
+			return err
+		}
+		return s.MFARepo.DeleteMFA(ctx, userID)
+	})
+}
+
+func (s *MFAService) RegenerateRecoveryCodes(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	var codes []string
+	err := s.MFARepo.Transaction(ctx, func(ctx context.Context) error {
+		err := s.VerifyCode(ctx, userID, code)
+		if err != nil {
+			return err
+		}
+		codes, err = s.replaceRecoveryCodes(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *MFAService) IsEnabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	mfa, err := s.MFARepo.GetMFA(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return mfa.ConfirmedAt != nil, nil
+}
+
+// VerifyCode accepts either a current TOTP code or an unused recovery code.
+// Each code works only once.
+func (s *MFAService) VerifyCode(ctx context.Context, userID uuid.UUID, code string) error {
+	mfa, err := s.MFARepo.GetMFA(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrMFANotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+	if mfa.ConfirmedAt == nil {
+		return ErrMFANotEnrolled
+	}
+
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) == totp.Digits {
+		return s.checkTOTP(ctx, mfa, code)
+	}
+
+	used, err := s.MFARepo.UseRecoveryCode(ctx, userID, utils.HashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+func (s *MFAService) checkTOTP(ctx context.Context, mfa *models.UserMFA, code string) error {
+	counter, ok := totp.Validate(mfa.Secret, code, time.Now())
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	fresh, err := s.MFARepo.UseCounter(ctx, mfa.UserID, counter)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+func (s *MFAService) replaceRecoveryCodes(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	var codes, hashes []string
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, utils.HashToken(raw))
+	}
+
+	err := s.MFARepo.ReplaceRecoveryCodes(ctx, userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(code, "-", ""))
+}
@@ -2,18 +2,80 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"log"
+	"sort"
+	"time"
 	"vigilant-spork/models"
+	"vigilant-spork/payments"
 	"vigilant-spork/repository"
 )
 
 type OrderService struct {
-	OrderRepo repository.OrderRepository
+	OrderRepo   repository.OrderRepository
+	AddressRepo repository.AddressRepository
+	Payments    payments.PaymentProvider
+	// PaymentTimeout bounds each provider call; zero means 15 seconds.
+	PaymentTimeout time.Duration
 }
 
-func (s *OrderService) MoveCartToOrder(ctx context.Context, userID uuid.UUID) error {
-	err := s.OrderRepo.Transaction(ctx, func(txRepo repository.OrderRepository) error {
+// CheckoutAddresses picks the order's addresses: an address book entry by ID
+// or an address given inline. Without either, shipping falls back to the
+// default address and billing to the shipping address.
+type CheckoutAddresses struct {
+	ShippingAddressID uuid.UUID
+	Shipping          *models.Address
+	BillingAddressID  uuid.UUID
+	Billing           *models.Address
+}
+
+var (
+	ErrInvalidTransition    = errors.New("invalid order status transition")
+	ErrUnknownOrderStatus   = errors.New("unknown order status")
+	ErrPaymentDeclined      = errors.New("payment declined")
+	ErrPaymentFailed        = errors.New("payment provider error")
+	ErrPaymentNotAuthorized = errors.New("order has no authorized payment to capture")
+	ErrPaymentInProgress    = errors.New("a payment operation for this order is still in progress")
+	ErrDuplicateEvent       = errors.New("payment event already processed")
+	ErrUnknownEventType     = errors.New("unknown payment event type")
+	ErrStaleEvent           = errors.New("payment event no longer applies")
+)
+
+var paymentEventStatuses = map[string]struct {
+	Payment string
+	Order   string
+}{
+	payments.EventAuthorized: {models.PaymentStatusAuthorized, models.OrderStatusAuthorized},
+	payments.EventCaptured:   {models.PaymentStatusCaptured, models.OrderStatusPaid},
+	payments.EventFailed:     {models.PaymentStatusDeclined, models.OrderStatusCancelled},
+	payments.EventRefunded:   {models.PaymentStatusRefunded, models.OrderStatusRefunded},
+}
+
+// defaultPaymentTimeout bounds each call to the payment provider when
+// OrderService.PaymentTimeout is not set.
+const defaultPaymentTimeout = 15 * time.Second
+
+// MoveCartToOrder turns the user's cart into an order and authorizes payment
+// for it. The addresses are copied onto the order. A declined payment cancels
+// the order and puts its items back in the cart. If the provider does not
+// give a definite answer the order is left PLACED with a PENDING payment for
+// ReconcilePayments to settle.
+func (s *OrderService) MoveCartToOrder(ctx context.Context, userID uuid.UUID, addresses CheckoutAddresses) (*models.Order, error) {
+	shipping, err := s.resolveAddress(ctx, userID, addresses.ShippingAddressID, addresses.Shipping, nil)
+	if err != nil {
+		return nil, err
+	}
+	billing, err := s.resolveAddress(ctx, userID, addresses.BillingAddressID, addresses.Billing, shipping)
+	if err != nil {
+		return nil, err
+	}
+
+	var order *models.Order
+	var payment *models.Payment
+	err = s.OrderRepo.Transaction(ctx, func(ctx context.Context, txRepo repository.OrderRepository) error {
 		cart, err := txRepo.GetCart(ctx, userID)
 		if err != nil {
 			return err
@@ -23,6 +85,10 @@ func (s *OrderService) MoveCartToOrder(ctx context.Context, userID uuid.UUID) er
 			return fmt.Errorf("cannot create order: cart is empty")
 		}
 
+		// lock variants in a fixed order so concurrent checkouts cannot deadlock
+		sort.Slice(cart.Items, func(i, j int) bool {
+			return cart.Items[i].VariantID.String() < cart.Items[j].VariantID.String()
+		})
 		for _, item := range cart.Items {
 			err := txRepo.VerifyAndDeductStock(ctx, &item)
 			if err != nil {
@@ -30,7 +96,17 @@ func (s *OrderService) MoveCartToOrder(ctx context.Context, userID uuid.UUID) er
 			}
 		}
 
-		order, err := txRepo.CreateOrder(ctx, userID)
+		order, err = txRepo.CreateOrder(ctx, userID, *shipping, *billing)
+		if err != nil {
+			return err
+		}
+
+		err = txRepo.AddStatusHistory(ctx, &models.OrderStatusHistory{
+			OrderID:   order.ID,
+			ToStatus:  order.Status,
+			ChangedBy: userID,
+			Note:      "checkout",
+		})
 		if err != nil {
 			return err
 		}
@@ -54,72 +130,565 @@ func (s *OrderService) MoveCartToOrder(ctx context.Context, userID uuid.UUID) er
 		if err != nil {
 			return err
 		}
+		order.Total = total
 
 		err = txRepo.ClearCart(ctx, cart.ID)
 		if err != nil {
 			return err
 		}
 
-		order, err = txRepo.GetOrder(ctx, userID)
+		err = transitionOrder(ctx, txRepo, order, models.OrderStatusPlaced, userID, "checkout")
 		if err != nil {
 			return err
 		}
 
-		order.Status = "ORDER PLACED"
+		if s.Payments == nil {
+			return nil
+		}
+		// the payment exists before the provider hears of it, so a crash
+		// mid-authorization leaves a PENDING payment to reconcile
+		payment = &models.Payment{
+			OrderID:  order.ID,
+			Provider: s.Payments.Name(),
+			Amount:   order.Total,
+			Status:   models.PaymentStatusPending,
+		}
+		return txRepo.CreatePayment(ctx, payment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if payment == nil {
+		return order, nil
+	}
 
-		err = txRepo.UpdateOrder(ctx, order)
+	order, err = s.authorize(ctx, payment, userID)
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// resolveAddress returns the snapshot for one checkout address. fallback is
+// used when neither an ID nor an inline address was given; a nil fallback
+// means the user's default address.
+func (s *OrderService) resolveAddress(ctx context.Context, userID, addressID uuid.UUID, inline *models.Address,
+	fallback *models.OrderAddress) (*models.OrderAddress, error) {
+	var address *models.Address
+	var err error
+
+	switch {
+	case addressID != uuid.Nil:
+		address, err = s.AddressRepo.GetAddress(ctx, userID, addressID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAddressNotFound
+		}
+	case inline != nil:
+		err = normalizeAddress(inline)
+		address = inline
+	case fallback != nil:
+		return fallback, nil
+	default:
+		address, err = s.AddressRepo.GetDefaultAddress(ctx, userID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAddressRequired
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := address.Snapshot()
+	return &snapshot, nil
+}
+
+func (s *OrderService) paymentTimeout() time.Duration {
+	if s.PaymentTimeout > 0 {
+		return s.PaymentTimeout
+	}
+	return defaultPaymentTimeout
+}
+
+// authorize asks the provider to authorize a PENDING payment and records the
+// outcome.
+func (s *OrderService) authorize(ctx context.Context, payment *models.Payment, actorID uuid.UUID) (*models.Order, error) {
+	callCtx, cancel := context.WithTimeout(ctx, s.paymentTimeout())
+	defer cancel()
+	result, authErr := s.Payments.Authorize(callCtx, payments.AuthorizeRequest{
+		PaymentID: payment.ID,
+		OrderID:   payment.OrderID,
+		Amount:    payment.Amount,
+	})
+
+	var order *models.Order
+	settle := false
+	err := s.OrderRepo.Transaction(ctx, func(ctx context.Context, txRepo repository.OrderRepository) error {
+		var err error
+		order, err = txRepo.LockOrder(ctx, payment.OrderID)
 		if err != nil {
 			return err
 		}
-		return nil
+
+		switch {
+		case errors.Is(authErr, payments.ErrDeclined):
+			payment.Status = models.PaymentStatusDeclined
+			payment.FailureReason = authErr.Error()
+			err = txRepo.UpdatePayment(ctx, payment)
+			if err != nil {
+				return err
+			}
+			if !models.CanTransitionOrder(order.Status, models.OrderStatusCancelled) {
+				return nil
+			}
+			err = transitionOrder(ctx, txRepo, order, models.OrderStatusCancelled, actorID, "payment declined")
+			if err != nil {
+				return err
+			}
+			return txRepo.RestoreCartItems(ctx, order.UserID, order.ID)
+		case authErr != nil:
+			// outcome unknown: keep the payment pending rather than guessing
+			payment.FailureReason = authErr.Error()
+			return txRepo.UpdatePayment(ctx, payment)
+		}
+
+		payment.Status = models.PaymentStatusAuthorized
+		payment.ProviderRef = result.Reference
+		payment.FailureReason = ""
+		if order.Status == models.OrderStatusCancelled {
+			// cancelled while the outcome was unknown: release the hold
+			payment.PendingStatus = models.OrderStatusCancelled
+			settle = true
+			return txRepo.UpdatePayment(ctx, payment)
+		}
+
+		err = txRepo.UpdatePayment(ctx, payment)
+		if err != nil {
+			return err
+		}
+		if order.Status != models.OrderStatusPlaced {
+			return nil
+		}
+		return transitionOrder(ctx, txRepo, order, models.OrderStatusAuthorized, actorID, "payment authorized")
 	})
-	return err
+	if err != nil {
+		return nil, err
+	}
+
+	if settle {
+		return s.settle(ctx, payment, actorID, "payment voided")
+	}
+	if errors.Is(authErr, payments.ErrDeclined) {
+		return order, ErrPaymentDeclined
+	}
+	return order, nil
 }
 
-func (s *OrderService) OrderPlaced(ctx context.Context, orderID uuid.UUID) error {
-	return s.OrderRepo.Transaction(ctx, func(txRepo repository.OrderRepository) error {
-		order, err := txRepo.GetOrder(ctx, orderID)
+// paymentToSettle returns the order's payment when money has to move before
+// the order can enter status to, or nil when nothing needs to happen. With a
+// provider configured an order can only become PAID once its payment has
+// been authorized.
+func (s *OrderService) paymentToSettle(ctx context.Context, txRepo repository.OrderRepository, orderID uuid.UUID,
+	to string) (*models.Payment, error) {
+	if s.Payments == nil {
+		return nil, nil
+	}
+
+	payment, err := txRepo.GetPaymentByOrderID(ctx, orderID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if to == models.OrderStatusPaid {
+			return nil, ErrPaymentNotAuthorized
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if payment.PendingStatus != "" {
+		return nil, ErrPaymentInProgress
+	}
+
+	switch to {
+	case models.OrderStatusPaid:
+		switch payment.Status {
+		case models.PaymentStatusAuthorized:
+			return payment, nil
+		case models.PaymentStatusCaptured:
+			return nil, nil
+		}
+		return nil, ErrPaymentNotAuthorized
+	case models.OrderStatusCancelled:
+		if payment.Status == models.PaymentStatusAuthorized || payment.Status == models.PaymentStatusCaptured {
+			return payment, nil
+		}
+	case models.OrderStatusRefunded:
+		if payment.Status == models.PaymentStatusCaptured {
+			return payment, nil
+		}
+	}
+	return nil, nil
+}
+
+// settle moves money for a payment whose PendingStatus is recorded, then
+// moves the order to that status. If the provider fails outright the
+// intent is dropped and the order stays where it was; if the outcome is
+// unknown the intent is kept for ReconcilePayments.
+func (s *OrderService) settle(ctx context.Context, payment *models.Payment, actorID uuid.UUID, note string) (*models.Order, error) {
+	callCtx, cancel := context.WithTimeout(ctx, s.paymentTimeout())
+	defer cancel()
+
+	to := payment.PendingStatus
+	status := payment.Status
+	var err error
+	switch {
+	case to == models.OrderStatusPaid && payment.Status == models.PaymentStatusAuthorized:
+		_, err = s.Payments.Capture(callCtx, payment.ProviderRef, payment.Amount)
+		status = models.PaymentStatusCaptured
+	case to == models.OrderStatusCancelled && payment.Status == models.PaymentStatusAuthorized:
+		_, err = s.Payments.Void(callCtx, payment.ProviderRef)
+		status = models.PaymentStatusVoided
+	case (to == models.OrderStatusCancelled || to == models.OrderStatusRefunded) && payment.Status == models.PaymentStatusCaptured:
+		_, err = s.Payments.Refund(callCtx, payment.ProviderRef, payment.Amount)
+		status = models.PaymentStatusRefunded
+	}
+	if err != nil && !definitePaymentFailure(err) {
+		return nil, fmt.Errorf("%w: %v", ErrPaymentFailed, err)
+	}
+
+	var order *models.Order
+	txErr := s.OrderRepo.Transaction(ctx, func(ctx context.Context, txRepo repository.OrderRepository) error {
+		var txErr error
+		order, txErr = txRepo.LockOrder(ctx, payment.OrderID)
+		if txErr != nil {
+			return txErr
+		}
+
+		payment.PendingStatus = ""
 		if err != nil {
-			return err
+			payment.FailureReason = err.Error()
+			return txRepo.UpdatePayment(ctx, payment)
+		}
+
+		payment.Status = status
+		txErr = txRepo.UpdatePayment(ctx, payment)
+		if txErr != nil {
+			return txErr
+		}
+		// the money has moved; an order that got ahead of us meanwhile (a
+		// webhook, say) keeps its status rather than undoing the payment
+		if order.Status == to || !models.CanTransitionOrder(order.Status, to) {
+			return nil
+		}
+		return transitionOrder(ctx, txRepo, order, to, actorID, note)
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPaymentFailed, err)
+	}
+	return order, nil
+}
+
+// definitePaymentFailure reports whether the provider refused an operation,
+// as opposed to failing without saying whether it happened.
+func definitePaymentFailure(err error) bool {
+	return errors.Is(err, payments.ErrDeclined) || errors.Is(err, payments.ErrInvalidState) ||
+		errors.Is(err, payments.ErrUnknownReference)
+}
+
+// ReconcilePayments finishes payment work that was interrupted: settlements
+// recorded but never completed, and authorizations whose outcome was unknown.
+// Only payments untouched for olderThan are considered, so requests still
+// talking to the provider are left alone.
+func (s *OrderService) ReconcilePayments(ctx context.Context, olderThan time.Duration) error {
+	if s.Payments == nil {
+		return nil
+	}
+
+	unsettled, err := s.OrderRepo.ListUnsettledPayments(ctx, time.Now().Add(-olderThan))
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for i := range unsettled {
+		payment := &unsettled[i]
+		if payment.PendingStatus != "" {
+			_, err = s.settle(ctx, payment, uuid.Nil, "payment reconciled")
+		} else {
+			_, err = s.authorize(ctx, payment, uuid.Nil)
+			if errors.Is(err, ErrPaymentDeclined) {
+				err = nil
+			}
 		}
-		if order.Status != "PENDING" {
-			return fmt.Errorf("cannot ship order in status %s", order.Status)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("payment %s: %w", payment.ID, err))
 		}
-		order.Status = "ORDER PLACED"
-		return txRepo.UpdateOrder(ctx, order)
+	}
+	return errors.Join(errs...)
+}
+
+func transitionOrder(ctx context.Context, txRepo repository.OrderRepository, order *models.Order, to string, actorID uuid.UUID, note string) error {
+	if !models.CanTransitionOrder(order.Status, to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, order.Status, to)
+	}
+
+	err := txRepo.UpdateOrderStatus(ctx, order.ID, order.Status, to)
+	if err != nil {
+		return err
+	}
+
+	err = txRepo.AddStatusHistory(ctx, &models.OrderStatusHistory{
+		OrderID:    order.ID,
+		FromStatus: order.Status,
+		ToStatus:   to,
+		ChangedBy:  actorID,
+		Note:       note,
 	})
+	if err != nil {
+		return err
+	}
+
+	order.Status = to
+
+	if to == models.OrderStatusCancelled || to == models.OrderStatusRefunded {
+		return restockOrder(ctx, txRepo, order.ID)
+	}
+	return nil
 }
 
-func (s *OrderService) ShipOrder(ctx context.Context, orderID uuid.UUID) error {
-	return s.OrderRepo.Transaction(ctx, func(txRepo repository.OrderRepository) error {
-		order, err := txRepo.GetOrder(ctx, orderID)
+func restockOrder(ctx context.Context, txRepo repository.OrderRepository, orderID uuid.UUID) error {
+	claimed, err := txRepo.MarkOrderRestocked(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return nil
+	}
+
+	items, err := txRepo.GetOrderItems(ctx, orderID)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if item.VariantID != nil {
+			err = txRepo.RestockVariant(ctx, *item.VariantID, item.Quantity)
+		} else {
+			err = txRepo.RestockProduct(ctx, item.ProductID, item.Quantity)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandlePaymentEvent applies an asynchronous provider notification to the
+// payment and its order. Every authentic event is recorded, even one that
+// arrives out of order and no longer applies, so the provider stops retrying
+// it; such events return ErrStaleEvent. Money only moves back through the
+// provider when the event leaves funds held against an order that no longer
+// wants them.
+func (s *OrderService) HandlePaymentEvent(ctx context.Context, provider string, event payments.WebhookEvent) error {
+	statuses, ok := paymentEventStatuses[event.Type]
+	if !ok {
+		return ErrUnknownEventType
+	}
+
+	var payment *models.Payment
+	var err error
+	if event.PaymentID != uuid.Nil {
+		payment, err = s.OrderRepo.GetPaymentByID(ctx, event.PaymentID)
+	} else {
+		payment, err = s.OrderRepo.GetPaymentByReference(ctx, provider, event.Reference)
+	}
+	if err != nil {
+		return err
+	}
+	if payment.Provider != provider {
+		return gorm.ErrRecordNotFound
+	}
+
+	stale := false
+	settle := false
+	err = s.OrderRepo.Transaction(ctx, func(ctx context.Context, txRepo repository.OrderRepository) error {
+		order, err := txRepo.LockOrder(ctx, payment.OrderID)
+		if err != nil {
+			return err
+		}
+		payment, err = txRepo.GetPaymentByID(ctx, payment.ID)
+		if err != nil {
+			return err
+		}
+
+		recorded, err := txRepo.RecordPaymentEvent(ctx, &models.PaymentEvent{
+			Provider:  provider,
+			EventID:   event.ID,
+			Type:      event.Type,
+			PaymentID: payment.ID,
+		})
+		if err != nil {
+			return err
+		}
+		if !recorded {
+			return ErrDuplicateEvent
+		}
+
+		if event.Reason != "" {
+			payment.FailureReason = event.Reason
+		}
+
+		// a failure reported after capture: the customer has paid for an
+		// order the provider now disowns, so refund before cancelling
+		if event.Type == payments.EventFailed && payment.Status == models.PaymentStatusCaptured {
+			to := models.OrderStatusCancelled
+			if !models.CanTransitionOrder(order.Status, to) {
+				to = models.OrderStatusRefunded
+			}
+			if payment.PendingStatus != "" || !models.CanTransitionOrder(order.Status, to) {
+				stale = true
+				return txRepo.UpdatePayment(ctx, payment)
+			}
+			payment.PendingStatus = to
+			settle = true
+			return txRepo.UpdatePayment(ctx, payment)
+		}
+
+		if !paymentEventApplies(payment.Status, statuses.Payment) {
+			stale = true
+			return nil
+		}
+
+		payment.Status = statuses.Payment
+		if event.Reference != "" {
+			payment.ProviderRef = event.Reference
+		}
+		if payment.PendingStatus == statuses.Order {
+			payment.PendingStatus = ""
+		}
+
+		// funds now held against an order that was closed meanwhile
+		held := payment.Status == models.PaymentStatusAuthorized || payment.Status == models.PaymentStatusCaptured
+		closed := order.Status == models.OrderStatusCancelled || order.Status == models.OrderStatusRefunded
+		if held && closed && payment.PendingStatus == "" {
+			payment.PendingStatus = order.Status
+			settle = true
+		}
+
+		err = txRepo.UpdatePayment(ctx, payment)
 		if err != nil {
 			return err
 		}
-		if order.Status != "PENDING" {
-			return fmt.Errorf("cannot ship order in status %s", order.Status)
+		if order.Status == statuses.Order || !models.CanTransitionOrder(order.Status, statuses.Order) {
+			return nil
 		}
-		order.Status = "SHIPPED"
-		return txRepo.UpdateOrder(ctx, order)
+		return transitionOrder(ctx, txRepo, order, statuses.Order, uuid.Nil, "webhook "+event.ID)
 	})
+	if err != nil {
+		return err
+	}
+
+	if settle {
+		_, err = s.settle(ctx, payment, uuid.Nil, "webhook "+event.ID)
+		if err != nil {
+			// the intent is recorded; ReconcilePayments will retry it
+			log.Printf("settling payment %s after webhook %s: %v", payment.ID, event.ID, err)
+		}
+	}
+	if stale {
+		return ErrStaleEvent
+	}
+	return nil
 }
 
-func (s *OrderService) CancelOrder(ctx context.Context, orderID uuid.UUID) error {
-	return s.OrderRepo.Transaction(ctx, func(txRepo repository.OrderRepository) error {
-		order, err := txRepo.GetOrder(ctx, orderID)
+// paymentEventApplies reports whether a webhook may move a payment from its
+// current status to next. Statuses only move forward, so a late event for
+// an earlier step is ignored.
+func paymentEventApplies(current, next string) bool {
+	switch next {
+	case models.PaymentStatusAuthorized:
+		return current == models.PaymentStatusPending
+	case models.PaymentStatusCaptured, models.PaymentStatusDeclined:
+		return current == models.PaymentStatusPending || current == models.PaymentStatusAuthorized
+	case models.PaymentStatusRefunded:
+		return current == models.PaymentStatusCaptured
+	}
+	return false
+}
+
+// TransitionOrder moves an order to a new status. When that means moving
+// money (capture on PAID, void or refund on CANCELLED/REFUNDED) the intent is
+// recorded on the payment together with the status check, the provider is
+// called, and the order only moves once the provider has done its part.
+func (s *OrderService) TransitionOrder(ctx context.Context, orderID uuid.UUID, to string, actorID uuid.UUID, note string) (*models.Order, error) {
+	if !models.IsValidOrderStatus(to) {
+		return nil, ErrUnknownOrderStatus
+	}
+
+	var order *models.Order
+	var payment *models.Payment
+	err := s.OrderRepo.Transaction(ctx, func(ctx context.Context, txRepo repository.OrderRepository) error {
+		var err error
+		order, err = txRepo.LockOrder(ctx, orderID)
 		if err != nil {
 			return err
 		}
-		if order.Status != "PENDING" {
-			return fmt.Errorf("cannot cancel order in status %s", order.Status)
+		if !models.CanTransitionOrder(order.Status, to) {
+			return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, order.Status, to)
 		}
-		order.Status = "CANCELLED"
-		return txRepo.UpdateOrder(ctx, order)
+
+		payment, err = s.paymentToSettle(ctx, txRepo, orderID, to)
+		if err != nil {
+			return err
+		}
+		if payment == nil {
+			return transitionOrder(ctx, txRepo, order, to, actorID, note)
+		}
+
+		payment.PendingStatus = to
+		return txRepo.UpdatePayment(ctx, payment)
 	})
+	if err != nil {
+		return nil, err
+	}
+	if payment == nil {
+		return order, nil
+	}
+	return s.settle(ctx, payment, actorID, note)
+}
+
+func (s *OrderService) GetStatusHistory(ctx context.Context, orderID uuid.UUID) ([]models.OrderStatusHistory, error) {
+	_, err := s.OrderRepo.GetOrderByID(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := s.OrderRepo.GetStatusHistory(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func (s *OrderService) GetOrder(ctx context.Context, userID, orderID uuid.UUID) (*models.Order, []models.OrderItem, error) {
+	order, err := s.OrderRepo.GetOrderByID(ctx, orderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if order.UserID != userID {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+
+	items, err := s.OrderRepo.GetOrderItems(ctx, orderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return order, items, nil
 }
 
-func (s *OrderService) GetOrderHistory(userID uuid.UUID) ([]models.Order, error) {
-	order, err := s.OrderRepo.GetOrderHistory(userID)
+func (s *OrderService) GetOrderHistory(ctx context.Context, userID uuid.UUID) ([]models.Order, error) {
+	order, err := s.OrderRepo.GetOrderHistory(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,645 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+	"vigilant-spork/models"
+	"vigilant-spork/payments"
+	"vigilant-spork/repository"
+)
+
+// memOrderRepo is an in-memory OrderRepository. Transactions do not roll
+// back, which is enough for the flows under test: each test checks the
+// state a successful or cleanly refused operation leaves behind.
+type memOrderRepo struct {
+	carts     map[uuid.UUID]*models.Cart // by user
+	stock     map[uuid.UUID]int          // by variant
+	orders    map[uuid.UUID]*models.Order
+	items     map[uuid.UUID][]models.OrderItem
+	history   []models.OrderStatusHistory
+	payments  map[uuid.UUID]*models.Payment
+	events    map[string]bool
+	restocked map[uuid.UUID]bool
+}
+
+func newMemOrderRepo() *memOrderRepo {
+	return &memOrderRepo{
+		carts:     map[uuid.UUID]*models.Cart{},
+		stock:     map[uuid.UUID]int{},
+		orders:    map[uuid.UUID]*models.Order{},
+		items:     map[uuid.UUID][]models.OrderItem{},
+		payments:  map[uuid.UUID]*models.Payment{},
+		events:    map[string]bool{},
+		restocked: map[uuid.UUID]bool{},
+	}
+}
+
+func (r *memOrderRepo) Transaction(ctx context.Context, fn func(ctx context.Context, repo repository.OrderRepository) error) error {
+	return fn(ctx, r)
+}
+
+func (r *memOrderRepo) GetCart(ctx context.Context, userID uuid.UUID) (*models.Cart, error) {
+	cart, ok := r.carts[userID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *cart
+	copied.Items = append([]models.CartItem(nil), cart.Items...)
+	return &copied, nil
+}
+
+func (r *memOrderRepo) VerifyAndDeductStock(ctx context.Context, cartItem *models.CartItem) error {
+	if r.stock[cartItem.VariantID] < cartItem.Quantity {
+		return repository.ErrInsufficientStock
+	}
+	r.stock[cartItem.VariantID] -= cartItem.Quantity
+	return nil
+}
+
+func (r *memOrderRepo) RestockProduct(ctx context.Context, productID uuid.UUID, quantity int) error {
+	return nil
+}
+
+func (r *memOrderRepo) RestockVariant(ctx context.Context, variantID uuid.UUID, quantity int) error {
+	r.stock[variantID] += quantity
+	return nil
+}
+
+func (r *memOrderRepo) MarkOrderRestocked(ctx context.Context, orderID uuid.UUID) (bool, error) {
+	if r.restocked[orderID] {
+		return false, nil
+	}
+	r.restocked[orderID] = true
+	return true, nil
+}
+
+func (r *memOrderRepo) CreateOrder(ctx context.Context, userID uuid.UUID, shipping, billing models.OrderAddress) (*models.Order, error) {
+	order := &models.Order{
+		ID:              uuid.Must(uuid.NewV4()),
+		UserID:          userID,
+		Status:          models.OrderStatusPending,
+		ShippingAddress: shipping,
+		BillingAddress:  billing,
+	}
+	r.orders[order.ID] = order
+	copied := *order
+	return &copied, nil
+}
+
+func (r *memOrderRepo) GetOrderByID(ctx context.Context, orderID uuid.UUID) (*models.Order, error) {
+	order, ok := r.orders[orderID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *order
+	return &copied, nil
+}
+
+func (r *memOrderRepo) LockOrder(ctx context.Context, orderID uuid.UUID) (*models.Order, error) {
+	return r.GetOrderByID(ctx, orderID)
+}
+
+func (r *memOrderRepo) UpdateOrder(ctx context.Context, order *models.Order) error {
+	copied := *order
+	r.orders[order.ID] = &copied
+	return nil
+}
+
+func (r *memOrderRepo) UpdateOrderStatus(ctx context.Context, orderID uuid.UUID, from, to string) error {
+	order, ok := r.orders[orderID]
+	if !ok || order.Status != from {
+		return repository.ErrStatusConflict
+	}
+	order.Status = to
+	return nil
+}
+
+func (r *memOrderRepo) AddStatusHistory(ctx context.Context, entry *models.OrderStatusHistory) error {
+	r.history = append(r.history, *entry)
+	return nil
+}
+
+func (r *memOrderRepo) GetStatusHistory(ctx context.Context, orderID uuid.UUID) ([]models.OrderStatusHistory, error) {
+	var history []models.OrderStatusHistory
+	for _, entry := range r.history {
+		if entry.OrderID == orderID {
+			history = append(history, entry)
+		}
+	}
+	return history, nil
+}
+
+func (r *memOrderRepo) MoveCartItemsToOrder(ctx context.Context, orderID uuid.UUID, cartID uuid.UUID) error {
+	for _, cart := range r.carts {
+		if cart.ID != cartID {
+			continue
+		}
+		for _, item := range cart.Items {
+			variantID := item.VariantID
+			r.items[orderID] = append(r.items[orderID], models.OrderItem{
+				OrderID:   orderID,
+				ProductID: item.ProductID,
+				VariantID: &variantID,
+				Quantity:  item.Quantity,
+				UnitPrice: item.UnitPrice,
+			})
+		}
+	}
+	return nil
+}
+
+func (r *memOrderRepo) ClearCart(ctx context.Context, cartID uuid.UUID) error {
+	for _, cart := range r.carts {
+		if cart.ID == cartID {
+			cart.Items = nil
+		}
+	}
+	return nil
+}
+
+func (r *memOrderRepo) RestoreCartItems(ctx context.Context, userID, orderID uuid.UUID) error {
+	cart := r.carts[userID]
+	for _, item := range r.items[orderID] {
+		cart.Items = append(cart.Items, models.CartItem{
+			CartID:    cart.ID,
+			ProductID: item.ProductID,
+			VariantID: *item.VariantID,
+			Quantity:  item.Quantity,
+			UnitPrice: item.UnitPrice,
+		})
+	}
+	return nil
+}
+
+func (r *memOrderRepo) GetOrderItems(ctx context.Context, orderID uuid.UUID) ([]models.OrderItem, error) {
+	return r.items[orderID], nil
+}
+
+func (r *memOrderRepo) UpdateOrderTotal(ctx context.Context, total int64, orderID uuid.UUID) error {
+	r.orders[orderID].Total = total
+	return nil
+}
+
+func (r *memOrderRepo) GetOrderHistory(ctx context.Context, userID uuid.UUID) ([]models.Order, error) {
+	var orders []models.Order
+	for _, order := range r.orders {
+		if order.UserID == userID {
+			orders = append(orders, *order)
+		}
+	}
+	return orders, nil
+}
+
+func (r *memOrderRepo) CreatePayment(ctx context.Context, payment *models.Payment) error {
+	payment.ID = uuid.Must(uuid.NewV4())
+	copied := *payment
+	r.payments[payment.ID] = &copied
+	return nil
+}
+
+func (r *memOrderRepo) UpdatePayment(ctx context.Context, payment *models.Payment) error {
+	copied := *payment
+	r.payments[payment.ID] = &copied
+	return nil
+}
+
+func (r *memOrderRepo) GetPaymentByOrderID(ctx context.Context, orderID uuid.UUID) (*models.Payment, error) {
+	for _, payment := range r.payments {
+		if payment.OrderID == orderID {
+			copied := *payment
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memOrderRepo) GetPaymentByID(ctx context.Context, paymentID uuid.UUID) (*models.Payment, error) {
+	payment, ok := r.payments[paymentID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *payment
+	return &copied, nil
+}
+
+func (r *memOrderRepo) GetPaymentByReference(ctx context.Context, provider, reference string) (*models.Payment, error) {
+	for _, payment := range r.payments {
+		if payment.Provider == provider && payment.ProviderRef == reference {
+			copied := *payment
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memOrderRepo) ListUnsettledPayments(ctx context.Context, before time.Time) ([]models.Payment, error) {
+	var unsettled []models.Payment
+	for _, payment := range r.payments {
+		if payment.Status == models.PaymentStatusPending || payment.PendingStatus != "" {
+			unsettled = append(unsettled, *payment)
+		}
+	}
+	return unsettled, nil
+}
+
+func (r *memOrderRepo) RecordPaymentEvent(ctx context.Context, event *models.PaymentEvent) (bool, error) {
+	key := event.Provider + "/" + event.EventID
+	if r.events[key] {
+		return false, nil
+	}
+	r.events[key] = true
+	return true, nil
+}
+
+// checkoutFixture is a user with one cart line of two units, at 1000 each,
+// of a variant with five in stock.
+type checkoutFixture struct {
+	repo      *memOrderRepo
+	provider  *payments.FakeProvider
+	service   *OrderService
+	userID    uuid.UUID
+	variantID uuid.UUID
+}
+
+func newCheckoutFixture(mode payments.FakeMode) *checkoutFixture {
+	f := &checkoutFixture{
+		repo:      newMemOrderRepo(),
+		provider:  payments.NewFakeProvider(mode),
+		userID:    uuid.Must(uuid.NewV4()),
+		variantID: uuid.Must(uuid.NewV4()),
+	}
+	f.service = &OrderService{OrderRepo: f.repo, Payments: f.provider, PaymentTimeout: 20 * time.Millisecond}
+
+	cartID := uuid.Must(uuid.NewV4())
+	f.repo.carts[f.userID] = &models.Cart{
+		ID:     cartID,
+		UserID: f.userID,
+		Items: []models.CartItem{{
+			CartID:    cartID,
+			ProductID: uuid.Must(uuid.NewV4()),
+			VariantID: f.variantID,
+			Quantity:  2,
+			UnitPrice: 1000,
+		}},
+	}
+	f.repo.stock[f.variantID] = 5
+	return f
+}
+
+func (f *checkoutFixture) checkout(t *testing.T) (*models.Order, error) {
+	t.Helper()
+	return f.service.MoveCartToOrder(context.Background(), f.userID, CheckoutAddresses{
+		Shipping: &models.Address{FullName: "Ada Lovelace", Line1: "1 Main St", City: "London", PostalCode: "N1", Country: "gb"},
+	})
+}
+
+// latest returns the stored order, payment and provider state for the only
+// order placed.
+func (f *checkoutFixture) latest(t *testing.T) (*models.Order, *models.Payment, string) {
+	t.Helper()
+	if len(f.repo.orders) != 1 {
+		t.Fatalf("want exactly one order, have %d", len(f.repo.orders))
+	}
+	for id, order := range f.repo.orders {
+		payment, err := f.repo.GetPaymentByOrderID(context.Background(), id)
+		if err != nil {
+			t.Fatalf("loading payment: %v", err)
+		}
+		return order, payment, f.provider.State(payment.ProviderRef)
+	}
+	return nil, nil, ""
+}
+
+func TestCheckoutAuthorizesPayment(t *testing.T) {
+	tests := []struct {
+		name          string
+		mode          payments.FakeMode
+		wantErr       error
+		wantOrder     string
+		wantPayment   string
+		wantStock     int
+		wantCartItems int
+	}{
+		{"succeed", payments.FakeSucceed, nil, models.OrderStatusAuthorized, models.PaymentStatusAuthorized, 3, 0},
+		{"decline", payments.FakeDecline, ErrPaymentDeclined, models.OrderStatusCancelled, models.PaymentStatusDeclined, 5, 1},
+		{"timeout", payments.FakeTimeout, nil, models.OrderStatusPlaced, models.PaymentStatusPending, 3, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newCheckoutFixture(tt.mode)
+
+			_, err := f.checkout(t)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("checkout error = %v, want %v", err, tt.wantErr)
+			}
+
+			order, payment, _ := f.latest(t)
+			if order.Status != tt.wantOrder {
+				t.Errorf("order status = %s, want %s", order.Status, tt.wantOrder)
+			}
+			if payment.Status != tt.wantPayment {
+				t.Errorf("payment status = %s, want %s", payment.Status, tt.wantPayment)
+			}
+			if payment.Amount != 2000 {
+				t.Errorf("payment amount = %d, want 2000", payment.Amount)
+			}
+			if got := f.repo.stock[f.variantID]; got != tt.wantStock {
+				t.Errorf("stock = %d, want %d", got, tt.wantStock)
+			}
+			if got := len(f.repo.carts[f.userID].Items); got != tt.wantCartItems {
+				t.Errorf("cart has %d lines, want %d", got, tt.wantCartItems)
+			}
+		})
+	}
+}
+
+func TestTransitionSettlesPayment(t *testing.T) {
+	tests := []struct {
+		name        string
+		steps       []string
+		wantOrder   string
+		wantPayment string
+		wantGateway string
+	}{
+		{"capture", []string{models.OrderStatusPaid}, models.OrderStatusPaid, models.PaymentStatusCaptured, "captured"},
+		{"void", []string{models.OrderStatusCancelled}, models.OrderStatusCancelled, models.PaymentStatusVoided, "voided"},
+		{"refund after capture", []string{models.OrderStatusPaid, models.OrderStatusRefunded},
+			models.OrderStatusRefunded, models.PaymentStatusRefunded, "refunded"},
+		{"cancel after capture", []string{models.OrderStatusPaid, models.OrderStatusCancelled},
+			models.OrderStatusCancelled, models.PaymentStatusRefunded, "refunded"},
+		{"ship without moving money", []string{models.OrderStatusPaid, models.OrderStatusShipped},
+			models.OrderStatusShipped, models.PaymentStatusCaptured, "captured"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newCheckoutFixture(payments.FakeSucceed)
+			order, err := f.checkout(t)
+			if err != nil {
+				t.Fatalf("checkout: %v", err)
+			}
+
+			for _, to := range tt.steps {
+				order, err = f.service.TransitionOrder(context.Background(), order.ID, to, uuid.Nil, "")
+				if err != nil {
+					t.Fatalf("transition to %s: %v", to, err)
+				}
+			}
+
+			stored, payment, gateway := f.latest(t)
+			if order.Status != tt.wantOrder || stored.Status != tt.wantOrder {
+				t.Errorf("order status = %s (stored %s), want %s", order.Status, stored.Status, tt.wantOrder)
+			}
+			if payment.Status != tt.wantPayment {
+				t.Errorf("payment status = %s, want %s", payment.Status, tt.wantPayment)
+			}
+			if payment.PendingStatus != "" {
+				t.Errorf("payment still pending %s", payment.PendingStatus)
+			}
+			if gateway != tt.wantGateway {
+				t.Errorf("gateway state = %s, want %s", gateway, tt.wantGateway)
+			}
+		})
+	}
+}
+
+func TestPaidRequiresAuthorizedPayment(t *testing.T) {
+	f := newCheckoutFixture(payments.FakeTimeout)
+	order, err := f.checkout(t)
+	if err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+
+	_, err = f.service.TransitionOrder(context.Background(), order.ID, models.OrderStatusPaid, uuid.Nil, "")
+	if !errors.Is(err, ErrPaymentNotAuthorized) {
+		t.Fatalf("transition error = %v, want %v", err, ErrPaymentNotAuthorized)
+	}
+
+	stored, _, _ := f.latest(t)
+	if stored.Status != models.OrderStatusPlaced {
+		t.Errorf("order status = %s, want %s", stored.Status, models.OrderStatusPlaced)
+	}
+}
+
+func TestReconcileTimedOutAuthorization(t *testing.T) {
+	tests := []struct {
+		name        string
+		later       payments.FakeMode
+		cancelFirst bool
+		wantOrder   string
+		wantPayment string
+	}{
+		{"authorized later", payments.FakeSucceed, false, models.OrderStatusAuthorized, models.PaymentStatusAuthorized},
+		{"declined later", payments.FakeDecline, false, models.OrderStatusCancelled, models.PaymentStatusDeclined},
+		{"still no answer", payments.FakeTimeout, false, models.OrderStatusPlaced, models.PaymentStatusPending},
+		{"authorized after cancel", payments.FakeSucceed, true, models.OrderStatusCancelled, models.PaymentStatusVoided},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newCheckoutFixture(payments.FakeTimeout)
+			order, err := f.checkout(t)
+			if err != nil {
+				t.Fatalf("checkout: %v", err)
+			}
+			if tt.cancelFirst {
+				_, err = f.service.TransitionOrder(context.Background(), order.ID, models.OrderStatusCancelled, uuid.Nil, "")
+				if err != nil {
+					t.Fatalf("cancel: %v", err)
+				}
+			}
+
+			f.provider.Mode = tt.later
+			err = f.service.ReconcilePayments(context.Background(), 0)
+			if err != nil {
+				t.Fatalf("reconcile: %v", err)
+			}
+
+			stored, payment, _ := f.latest(t)
+			if stored.Status != tt.wantOrder {
+				t.Errorf("order status = %s, want %s", stored.Status, tt.wantOrder)
+			}
+			if payment.Status != tt.wantPayment {
+				t.Errorf("payment status = %s, want %s", payment.Status, tt.wantPayment)
+			}
+		})
+	}
+}
+
+func TestReconcileFinishesInterruptedSettlement(t *testing.T) {
+	f := newCheckoutFixture(payments.FakeSucceed)
+	order, err := f.checkout(t)
+	if err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+
+	// the capture intent was recorded but the process stopped before the
+	// provider answered
+	_, payment, _ := f.latest(t)
+	payment.PendingStatus = models.OrderStatusPaid
+	f.repo.payments[payment.ID] = payment
+
+	_, err = f.service.TransitionOrder(context.Background(), order.ID, models.OrderStatusCancelled, uuid.Nil, "")
+	if !errors.Is(err, ErrPaymentInProgress) {
+		t.Fatalf("transition during settlement error = %v, want %v", err, ErrPaymentInProgress)
+	}
+
+	err = f.service.ReconcilePayments(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	stored, payment, gateway := f.latest(t)
+	if stored.Status != models.OrderStatusPaid || payment.Status != models.PaymentStatusCaptured || gateway != "captured" {
+		t.Errorf("after reconcile order=%s payment=%s gateway=%s, want PAID/CAPTURED/captured",
+			stored.Status, payment.Status, gateway)
+	}
+}
+
+func TestOrderTransitions(t *testing.T) {
+	tests := []struct {
+		from    string
+		to      string
+		wantErr error
+	}{
+		{models.OrderStatusPlaced, models.OrderStatusPaid, nil},
+		{models.OrderStatusAuthorized, models.OrderStatusPaid, nil},
+		{models.OrderStatusPaid, models.OrderStatusShipped, nil},
+		{models.OrderStatusShipped, models.OrderStatusDelivered, nil},
+		{models.OrderStatusDelivered, models.OrderStatusRefunded, nil},
+		{models.OrderStatusPaid, models.OrderStatusCancelled, nil},
+		{models.OrderStatusPlaced, models.OrderStatusShipped, ErrInvalidTransition},
+		{models.OrderStatusShipped, models.OrderStatusCancelled, ErrInvalidTransition},
+		{models.OrderStatusCancelled, models.OrderStatusPlaced, ErrInvalidTransition},
+		{models.OrderStatusRefunded, models.OrderStatusPaid, ErrInvalidTransition},
+		{models.OrderStatusPaid, "LOST", ErrUnknownOrderStatus},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			repo := newMemOrderRepo()
+			service := &OrderService{OrderRepo: repo}
+			order := &models.Order{ID: uuid.Must(uuid.NewV4()), Status: tt.from}
+			repo.orders[order.ID] = order
+
+			updated, err := service.TransitionOrder(context.Background(), order.ID, tt.to, uuid.Nil, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+
+			want := tt.to
+			if tt.wantErr != nil {
+				want = tt.from
+			} else if updated.Status != want {
+				t.Errorf("returned status = %s, want %s", updated.Status, want)
+			}
+			if repo.orders[order.ID].Status != want {
+				t.Errorf("stored status = %s, want %s", repo.orders[order.ID].Status, want)
+			}
+			if tt.wantErr == nil && len(repo.history) != 1 {
+				t.Errorf("history has %d entries, want 1", len(repo.history))
+			}
+		})
+	}
+}
+
+func TestHandlePaymentEvent(t *testing.T) {
+	tests := []struct {
+		name        string
+		steps       []string // transitions before the event
+		mode        payments.FakeMode
+		event       string
+		wantErr     error
+		wantOrder   string
+		wantPayment string
+	}{
+		{"capture reported", nil, payments.FakeSucceed, payments.EventCaptured, nil,
+			models.OrderStatusPaid, models.PaymentStatusCaptured},
+		{"authorized after capture", []string{models.OrderStatusPaid}, payments.FakeSucceed, payments.EventAuthorized, ErrStaleEvent,
+			models.OrderStatusPaid, models.PaymentStatusCaptured},
+		{"authorization resolved", nil, payments.FakeTimeout, payments.EventAuthorized, nil,
+			models.OrderStatusAuthorized, models.PaymentStatusAuthorized},
+		{"failed before capture", nil, payments.FakeSucceed, payments.EventFailed, nil,
+			models.OrderStatusCancelled, models.PaymentStatusDeclined},
+		{"failed after capture refunds", []string{models.OrderStatusPaid}, payments.FakeSucceed, payments.EventFailed, nil,
+			models.OrderStatusCancelled, models.PaymentStatusRefunded},
+		{"captured after void", []string{models.OrderStatusCancelled}, payments.FakeSucceed, payments.EventCaptured, ErrStaleEvent,
+			models.OrderStatusCancelled, models.PaymentStatusVoided},
+		{"refund after capture", []string{models.OrderStatusPaid}, payments.FakeSucceed, payments.EventRefunded, nil,
+			models.OrderStatusRefunded, models.PaymentStatusRefunded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newCheckoutFixture(tt.mode)
+			order, err := f.checkout(t)
+			if err != nil {
+				t.Fatalf("checkout: %v", err)
+			}
+			for _, to := range tt.steps {
+				_, err = f.service.TransitionOrder(context.Background(), order.ID, to, uuid.Nil, "")
+				if err != nil {
+					t.Fatalf("transition to %s: %v", to, err)
+				}
+			}
+
+			_, payment, _ := f.latest(t)
+			event := payments.WebhookEvent{ID: "evt-1", Type: tt.event, PaymentID: payment.ID}
+			err = f.service.HandlePaymentEvent(context.Background(), f.provider.Name(), event)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+
+			stored, payment, _ := f.latest(t)
+			if stored.Status != tt.wantOrder {
+				t.Errorf("order status = %s, want %s", stored.Status, tt.wantOrder)
+			}
+			if payment.Status != tt.wantPayment {
+				t.Errorf("payment status = %s, want %s", payment.Status, tt.wantPayment)
+			}
+			if !f.repo.events[f.provider.Name()+"/evt-1"] {
+				t.Errorf("event was not recorded")
+			}
+
+			err = f.service.HandlePaymentEvent(context.Background(), f.provider.Name(), event)
+			if !errors.Is(err, ErrDuplicateEvent) {
+				t.Errorf("redelivery error = %v, want %v", err, ErrDuplicateEvent)
+			}
+		})
+	}
+}
+
+func TestLateAuthorizationOfCancelledOrderIsVoided(t *testing.T) {
+	f := newCheckoutFixture(payments.FakeTimeout)
+	order, err := f.checkout(t)
+	if err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+	_, err = f.service.TransitionOrder(context.Background(), order.ID, models.OrderStatusCancelled, uuid.Nil, "")
+	if err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+
+	// the gateway went through after all and says so
+	_, payment, _ := f.latest(t)
+	f.provider.Mode = payments.FakeSucceed
+	result, err := f.provider.Authorize(context.Background(), payments.AuthorizeRequest{PaymentID: payment.ID, Amount: payment.Amount})
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	event := payments.WebhookEvent{ID: "evt-1", Type: payments.EventAuthorized, Reference: result.Reference, PaymentID: payment.ID}
+	err = f.service.HandlePaymentEvent(context.Background(), f.provider.Name(), event)
+	if err != nil {
+		t.Fatalf("handling event: %v", err)
+	}
+
+	stored, payment, gateway := f.latest(t)
+	if stored.Status != models.OrderStatusCancelled || payment.Status != models.PaymentStatusVoided || gateway != "voided" {
+		t.Errorf("order=%s payment=%s gateway=%s, want CANCELLED/VOIDED/voided", stored.Status, payment.Status, gateway)
+	}
+}
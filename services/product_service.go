@@ -1,20 +1,34 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/gofrs/uuid"
 	"gorm.io/gorm"
+	"strings"
 	"vigilant-spork/models"
 	"vigilant-spork/repository"
 )
 
 type ProductService struct {
-	ProductRepo repository.ProductRepository
+	ProductRepo  repository.ProductRepository
+	CategoryRepo repository.CategoryRepository
+	VariantRepo  repository.VariantRepository
 }
 
-func (s *ProductService) AddProduct(products []models.Product) error {
-	for _, product := range products {
+// ErrStockByVariant is returned when stock is set on a product with several
+// variants, whose stock is kept per variant.
+var ErrStockByVariant = errors.New("product has several variants; set stock on each variant")
+
+func (s *ProductService) AddProduct(ctx context.Context, products []models.Product) error {
+	for i, product := range products {
+		// products are filed by category_id; a nested category object is not created
+		products[i].Category = nil
+		// a new product gets a single default variant; others are added later
+		products[i].Options = nil
+		products[i].Variants = nil
+
 		if product.Name == "" {
 			return errors.New("product name is required")
 		}
@@ -23,8 +37,13 @@ func (s *ProductService) AddProduct(products []models.Product) error {
 			return errors.New("product description is required")
 		}
 
-		if product.Category == "" {
-			return errors.New("product category is required")
+		if product.CategoryID == nil {
+			return errors.New("product category_id is required")
+		}
+
+		err := s.ensureCategoryExists(ctx, *product.CategoryID)
+		if err != nil {
+			return err
 		}
 
 		if product.Price == 0 {
@@ -35,7 +54,7 @@ func (s *ProductService) AddProduct(products []models.Product) error {
 			return errors.New("stock quantity is required and cannot be 0")
 		}
 
-		existingProduct, err := s.ProductRepo.GetProductByName(product.Name)
+		existingProduct, err := s.ProductRepo.GetProductByName(ctx, product.Name)
 		if err == nil && existingProduct != nil {
 			return fmt.Errorf("product with name %q already exists", product.Name)
 		}
@@ -45,73 +64,146 @@ func (s *ProductService) AddProduct(products []models.Product) error {
 		}
 	}
 
-	err := s.ProductRepo.AddProduct(products)
+	err := s.ProductRepo.AddProduct(ctx, products)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (s *ProductService) GetProductByID(productID uuid.UUID) (*models.Product, error) {
-	product, err := s.ProductRepo.GetProductByID(productID)
+func (s *ProductService) GetProductByID(ctx context.Context, productID uuid.UUID) (*models.Product, error) {
+	product, err := s.ProductRepo.GetProductByID(ctx, productID)
 	if err != nil {
 		return nil, err
 	}
 	return product, nil
 }
 
-func (s *ProductService) GetProducts(page int, limit int, minPrice int, maxPrice int, category string) ([]models.Product, error) {
+// GetProducts returns one page of products and the cursor for the next page
+// ("" on the last page or for relevance-ranked results). A cursor takes
+// precedence over page.
+func (s *ProductService) GetProducts(ctx context.Context, page int, limit int, filter repository.ProductFilter,
+	sort string, cursor string) ([]models.Product, string, error) {
+	sort, err := repository.ResolveProductSort(sort, filter.Query)
+	if err != nil {
+		return nil, "", err
+	}
+
 	offset := (page - 1) * limit
+	if cursor != "" {
+		offset = 0
+	}
 
-	products, err := s.ProductRepo.GetProducts(limit, offset, minPrice, maxPrice, category)
+	// one extra row tells us whether there is a next page
+	products, err := s.ProductRepo.GetProducts(ctx, limit+1, offset, filter, sort, cursor)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return products, nil
+	if len(products) <= limit {
+		return products, "", nil
+	}
+
+	products = products[:limit]
+	next, err := repository.ProductCursor(sort, &products[limit-1])
+	if err != nil {
+		return nil, "", err
+	}
+	return products, next, nil
 }
 
-func (s *ProductService) GetTotalItems() (int64, error) {
-	totalItems, err := s.ProductRepo.GetProductsMetadata()
+// SuggestProducts returns product names for type-ahead. Queries shorter than
+// two characters match too much to be useful and return nothing.
+func (s *ProductService) SuggestProducts(ctx context.Context, query string, limit int) ([]repository.ProductSuggestion, error) {
+	if len([]rune(strings.TrimSpace(query))) < 2 {
+		return []repository.ProductSuggestion{}, nil
+	}
+	return s.ProductRepo.SuggestProducts(ctx, query, limit)
+}
+
+// GetTotalItems counts the products matching filter.
+func (s *ProductService) GetTotalItems(ctx context.Context, filter repository.ProductFilter) (int64, error) {
+	totalItems, err := s.ProductRepo.CountProducts(ctx, filter)
 	if err != nil {
 		return 0, err
 	}
 	return totalItems, nil
 }
 
-func (s *ProductService) UpdateProduct(productID uuid.UUID, req *models.Product) (*models.Product, error) {
-	product, err := s.ProductRepo.GetProductByID(productID)
+func (s *ProductService) GetFacets(ctx context.Context, filter repository.ProductFilter) (*repository.ProductFacets, error) {
+	facets, err := s.ProductRepo.GetProductFacets(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
+	return facets, nil
+}
 
-	if req.Name != "" {
-		product.Name = req.Name
-	}
-	if req.Description != "" {
-		product.Description = req.Description
-	}
-	if req.Category != "" {
-		product.Category = req.Category
-	}
-	if req.Price != 0.0 {
-		product.Price = req.Price
-	}
+// UpdateProduct changes the fields set in req. Stock is never written from
+// the product read here, which may be stale by the time it is saved; it is
+// recomputed from the variants in the same transaction instead.
+func (s *ProductService) UpdateProduct(ctx context.Context, productID uuid.UUID, req *models.Product) (*models.Product, error) {
+	var updatedProduct *models.Product
+	err := s.VariantRepo.Transaction(ctx, func(ctx context.Context) error {
+		product, err := s.ProductRepo.GetProductByID(ctx, productID)
+		if err != nil {
+			return err
+		}
 
-	if req.StockQuantity != 0 {
-		product.StockQuantity = req.StockQuantity
-	}
+		if req.Name != "" {
+			product.Name = req.Name
+		}
+		if req.Description != "" {
+			product.Description = req.Description
+		}
+		if req.CategoryID != nil {
+			err = s.ensureCategoryExists(ctx, *req.CategoryID)
+			if err != nil {
+				return err
+			}
+			product.CategoryID = req.CategoryID
+		}
+		if req.Price != 0.0 {
+			product.Price = req.Price
+		}
+
+		if req.StockQuantity != 0 {
+			// product stock is the sum of its variants', so it can only be
+			// set directly when there is one variant to put it on
+			if len(product.Variants) != 1 {
+				return ErrStockByVariant
+			}
+			variant, err := s.VariantRepo.LockVariant(ctx, product.ID, product.Variants[0].ID)
+			if err != nil {
+				return err
+			}
+			variant.StockQuantity = req.StockQuantity
+			err = s.VariantRepo.UpdateVariant(ctx, variant, true)
+			if err != nil {
+				return err
+			}
+		}
 
-	updatedProduct, err := s.ProductRepo.UpdateProduct(product)
+		// also resyncs the product's stock and price_from from its variants
+		updatedProduct, err = s.ProductRepo.UpdateProduct(ctx, product)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	return updatedProduct, nil
 }
 
-func (s *ProductService) DeleteProduct(productID uuid.UUID) error {
-	err := s.ProductRepo.DeleteProduct(productID)
+func (s *ProductService) DeleteProduct(ctx context.Context, productID uuid.UUID) error {
+	err := s.ProductRepo.DeleteProduct(ctx, productID)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+func (s *ProductService) ensureCategoryExists(ctx context.Context, categoryID uuid.UUID) error {
+	_, err := s.CategoryRepo.GetCategory(ctx, categoryID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrUnknownCategory
+	}
+	return err
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"github.com/gofrs/uuid"
 	"sync"
@@ -30,7 +31,7 @@ func NewReviewService(reviewRepo repository.ReviewRepository, productRepo reposi
 	}
 }
 
-func (s *ReviewService) SubmitReview(review *models.Review) error {
+func (s *ReviewService) SubmitReview(ctx context.Context, review *models.Review) error {
 	if review.Rating < 1 || review.Rating > 5 {
 		return ErrInvalidRating
 	}
@@ -53,49 +54,58 @@ func (s *ReviewService) SubmitReview(review *models.Review) error {
 	s.rateLimiter[review.UserID] = recent
 	s.mu.Unlock()
 
-	existing, _ := s.ReviewRepo.GetReviewByUserForProduct(review.UserID, review.ProductID)
+	existing, _ := s.ReviewRepo.GetReviewByUserForProduct(ctx, review.UserID, review.ProductID)
 	if existing != nil {
 		existing.Title = review.Title
 		existing.Description = review.Description
 		existing.Rating = review.Rating
-		if err := s.ReviewRepo.UpdateReview(existing); err != nil {
+		if err := s.ReviewRepo.UpdateReview(ctx, existing); err != nil {
 			return err
 		}
 	} else {
-		if err := s.ReviewRepo.CreateReview(review); err != nil {
+		if err := s.ReviewRepo.CreateReview(ctx, review); err != nil {
 			return err
 		}
 	}
 
-	avg, count, err := s.ReviewRepo.CalculateProductReviewAggregates(review.ProductID)
+	avg, count, err := s.ReviewRepo.CalculateProductReviewAggregates(ctx, review.ProductID)
 	if err != nil {
 		return err
 	}
-	err = s.ProductRepo.UpdateAggregates(review.ProductID, avg, count)
+	err = s.ProductRepo.UpdateAggregates(ctx, review.ProductID, avg, count)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (s *ReviewService) GetReviewsForProduct(productID uuid.UUID) ([]models.Review, error) {
-	reviews, err := s.ReviewRepo.GetReviewsByProductID(productID)
+func (s *ReviewService) GetReviewsForProduct(ctx context.Context, productID uuid.UUID) ([]models.Review, error) {
+	reviews, err := s.ReviewRepo.GetReviewsByProductID(ctx, productID)
 	if err != nil {
 		return nil, err
 	}
 	return reviews, nil
 }
 
-func (s *ReviewService) GetReviewByUserForProduct(userID, productID uuid.UUID) (*models.Review, error) {
-	review, err := s.ReviewRepo.GetReviewByUserForProduct(userID, productID)
+func (s *ReviewService) GetReviewByUserForProduct(ctx context.Context, userID, productID uuid.UUID) (*models.Review, error) {
+	review, err := s.ReviewRepo.GetReviewByUserForProduct(ctx, userID, productID)
 	if err != nil {
 		return nil, err
 	}
 	return review, nil
 
 }
-func (s *ReviewService) UpdateReview(review *models.Review) error {
-	existing, err := s.ReviewRepo.GetReviewByUserForProduct(review.UserID, review.ProductID)
+
+func (s *ReviewService) GetReviewByID(ctx context.Context, reviewID uuid.UUID) (*models.Review, error) {
+	review, err := s.ReviewRepo.GetReviewByID(ctx, reviewID)
+	if err != nil || review == nil {
+		return nil, ErrReviewNotFound
+	}
+	return review, nil
+}
+
+func (s *ReviewService) UpdateReview(ctx context.Context, review *models.Review) error {
+	existing, err := s.ReviewRepo.GetReviewByUserForProduct(ctx, review.UserID, review.ProductID)
 	if err != nil || existing == nil {
 		return ErrReviewNotFound
 	}
@@ -104,36 +114,36 @@ func (s *ReviewService) UpdateReview(review *models.Review) error {
 	existing.Description = review.Description
 	existing.Rating = review.Rating
 
-	if err := s.ReviewRepo.UpdateReview(existing); err != nil {
+	if err := s.ReviewRepo.UpdateReview(ctx, existing); err != nil {
 		return err
 	}
 
-	avg, count, err := s.ReviewRepo.CalculateProductReviewAggregates(review.ProductID)
+	avg, count, err := s.ReviewRepo.CalculateProductReviewAggregates(ctx, review.ProductID)
 	if err != nil {
 		return err
 	}
-	err = s.ProductRepo.UpdateAggregates(review.ProductID, avg, count)
+	err = s.ProductRepo.UpdateAggregates(ctx, review.ProductID, avg, count)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (s *ReviewService) DeleteReview(reviewID uuid.UUID) error {
-	review, err := s.ReviewRepo.GetReviewByID(reviewID)
+func (s *ReviewService) DeleteReview(ctx context.Context, reviewID uuid.UUID) error {
+	review, err := s.ReviewRepo.GetReviewByID(ctx, reviewID)
 	if err != nil || review == nil {
 		return ErrReviewNotFound
 	}
 
-	if err := s.ReviewRepo.DeleteReview(reviewID); err != nil {
+	if err := s.ReviewRepo.DeleteReview(ctx, reviewID); err != nil {
 		return err
 	}
 
-	avg, count, err := s.ReviewRepo.CalculateProductReviewAggregates(review.ProductID)
+	avg, count, err := s.ReviewRepo.CalculateProductReviewAggregates(ctx, review.ProductID)
 	if err != nil {
 		return err
 	}
-	err = s.ProductRepo.UpdateAggregates(review.ProductID, avg, count)
+	err = s.ProductRepo.UpdateAggregates(ctx, review.ProductID, avg, count)
 	if err != nil {
 		return err
 	}
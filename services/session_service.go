@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"time"
+	"vigilant-spork/middleware"
+	"vigilant-spork/models"
+	"vigilant-spork/repository"
+	"vigilant-spork/utils"
+)
+
+type SessionService struct {
+	SessionRepo     repository.SessionRepository
+	UserRepo        repository.UserRepository
+	Keys            *middleware.KeySet
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected, session revoked")
+	ErrSessionNotFound     = errors.New("session not found")
+)
+
+// StartSession records a new logged-in device for the user and issues its
+// first token pair. mfaVerified says whether the login passed a second factor.
+func (s *SessionService) StartSession(ctx context.Context, user *models.User, userAgent, ip string, mfaVerified bool) (*TokenPair, error) {
+	var pair *TokenPair
+	err := s.SessionRepo.Transaction(ctx, func(ctx context.Context) error {
+		now := time.Now()
+		session := &models.Session{
+			UserID:      user.ID,
+			UserAgent:   userAgent,
+			IPAddress:   ip,
+			ExpiresAt:   now.Add(s.RefreshTokenTTL),
+			LastUsedAt:  now,
+			MFAVerified: mfaVerified,
+		}
+		err := s.SessionRepo.CreateSession(ctx, session)
+		if err != nil {
+			return err
+		}
+
+		pair, err = s.issueTokens(ctx, user, session.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// Refresh rotates a refresh token: the presented token is consumed and a new
+// pair is issued for the same session. Presenting a token that was already
+// consumed means it leaked, so the whole session is revoked.
+func (s *SessionService) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	var pair *TokenPair
+	var replayedSession uuid.UUID
+
+	err := s.SessionRepo.Transaction(ctx, func(ctx context.Context) error {
+		token, err := s.SessionRepo.GetRefreshTokenByHash(ctx, utils.HashToken(refreshToken))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+
+		fresh, err := s.SessionRepo.MarkRefreshTokenUsed(ctx, token.ID)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			replayedSession = token.SessionID
+			return ErrRefreshTokenReused
+		}
+
+		now := time.Now()
+		if token.ExpiresAt.Before(now) {
+			return ErrInvalidRefreshToken
+		}
+
+		session, err := s.SessionRepo.GetSession(ctx, token.SessionID)
+		if err != nil {
+			return err
+		}
+		if session.RevokedAt != nil || session.ExpiresAt.Before(now) {
+			return ErrInvalidRefreshToken
+		}
+
+		user, err := s.UserRepo.GetUserByID(ctx, session.UserID)
+		if err != nil {
+			return err
+		}
+		if user.DisabledAt != nil {
+			return ErrAccountDisabled
+		}
+
+		pair, err = s.issueTokens(ctx, user, session.ID)
+		if err != nil {
+			return err
+		}
+		return s.SessionRepo.TouchSession(ctx, session.ID, now.Add(s.RefreshTokenTTL))
+	})
+
+	if errors.Is(err, ErrRefreshTokenReused) {
+		revokeErr := s.SessionRepo.RevokeSession(ctx, replayedSession)
+		if revokeErr != nil {
+			return nil, revokeErr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+func (s *SessionService) issueTokens(ctx context.Context, user *models.User, sessionID uuid.UUID) (*TokenPair, error) {
+	refreshToken, err := utils.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.SessionRepo.CreateRefreshToken(ctx, &models.RefreshToken{
+		SessionID: sessionID,
+		TokenHash: utils.HashToken(refreshToken),
+		ExpiresAt: time.Now().Add(s.RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := middleware.GenerateJWT(s.Keys, user.ID, user.Role, sessionID, s.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+func (s *SessionService) ListSessions(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	sessions, err := s.SessionRepo.ListActiveSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (s *SessionService) RevokeSession(ctx context.Context, userID, sessionID uuid.UUID) error {
+	session, err := s.SessionRepo.GetSession(ctx, sessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if session.UserID != userID {
+		return ErrSessionNotFound
+	}
+	return s.SessionRepo.RevokeSession(ctx, sessionID)
+}
+
+func (s *SessionService) RevokeAllSessions(ctx context.Context, userID uuid.UUID) error {
+	return s.SessionRepo.RevokeUserSessions(ctx, userID)
+}
+
+func (s *SessionService) RevokeOtherSessions(ctx context.Context, userID, keepSessionID uuid.UUID) error {
+	return s.SessionRepo.RevokeOtherSessions(ctx, userID, keepSessionID)
+}
@@ -1,10 +1,18 @@
 package services
 
 import (
+	"context"
 	"errors"
-	"os"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"log"
+	"net/url"
 	"regexp"
-	"vigilant-spork/middleware"
+	"strings"
+	"sync"
+	"time"
+	"vigilant-spork/mail"
 	"vigilant-spork/models"
 	"vigilant-spork/repository"
 	"vigilant-spork/utils"
@@ -12,66 +20,943 @@ import (
 
 type UserService struct {
 	UserRepo repository.UserRepository
+	RoleRepo repository.RoleRepository
+	Sessions *SessionService
+	Guard    *LoginGuard
+	MFA      *MFAService
+	Mail     mail.Sender
+
+	// AppURL is the public base URL used to build links in emails.
+	AppURL           string
+	PasswordResetTTL time.Duration
+	VerificationTTL  time.Duration
+}
+
+// mfaChallengeTTL is how long the user has to enter their code after the
+// password step.
+const mfaChallengeTTL = 5 * time.Minute
+
+// LoginResult holds either the tokens or, for users with MFA enabled, the
+// challenge to complete with CompleteMFALogin.
+type LoginResult struct {
+	Tokens    *TokenPair
+	Challenge *MFAChallenge
 }
 
-var ErrEmailExists = errors.New("email already registered")
+type MFAChallenge struct {
+	MFARequired    bool   `json:"mfa_required"`
+	ChallengeToken string `json:"challenge_token"`
+	ExpiresIn      int64  `json:"expires_in"`
+}
+
+const (
+	// a new verification email can be requested once a minute, at most five
+	// times an hour
+	resendInterval    = time.Minute
+	resendHourlyLimit = 5
+)
+
+var (
+	ErrInvalidEmail        = errors.New("invalid email format")
+	ErrEmailExists         = errors.New("email already registered")
+	ErrInvalidCredentials  = errors.New("invalid credentials")
+	ErrReauthFailed        = errors.New("current password or authentication code is incorrect")
+	ErrMFACodeRequired     = errors.New("an authentication code is required")
+	ErrInvalidName         = errors.New("name must be between 1 and 100 characters")
+	ErrEmailUnchanged      = errors.New("that is already your email address")
+	ErrInvalidEmailToken   = errors.New("email change link is invalid or has expired")
+	ErrInvalidMFAChallenge = errors.New("login challenge is invalid or has expired")
+	ErrAccountDisabled     = errors.New("account is disabled")
+	ErrInvalidRole         = errors.New("unknown role")
+	ErrInvalidRoleName     = errors.New("role name must be 1-50 lowercase letters, digits, '-' or '_'")
+	ErrUnknownPermission   = errors.New("unknown permission")
+	ErrBuiltInRole         = errors.New("the admin and customer roles cannot be modified")
+	ErrWeakPassword        = errors.New("password must be at least 8 characters")
+	ErrInvalidResetToken   = errors.New("reset token is invalid or has expired")
+	ErrInvalidVerifyToken  = errors.New("verification link is invalid or has expired")
+	ErrAlreadyVerified     = errors.New("email address is already verified")
+	ErrResendThrottled     = errors.New("a verification email was sent recently, try again later")
+	ErrPrivilegeEscalation = errors.New("cannot grant or manage permissions you do not have")
+	ErrLastAdmin           = errors.New("cannot remove the last active admin")
+	ErrCannotDisableSelf   = errors.New("admins cannot disable their own account")
+	ErrBootstrapEmailUsed  = errors.New("bootstrap admin email belongs to an existing non-admin user")
+)
 
 func isValidEmail(email string) bool {
 	regex := regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
 	return regex.MatchString(email)
 }
 
-func (s *UserService) RegisterUser(user *models.User) error {
+// RegisterUser creates a customer account and emails a verification link. A
+// failed email does not fail the signup; the user can ask for another link.
+func (s *UserService) RegisterUser(ctx context.Context, user *models.User) error {
+	err := s.createUser(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	err = s.sendVerificationEmail(ctx, user)
+	if err != nil {
+		log.Printf("sending verification email to user %s: %v", user.ID, err)
+	}
+	return nil
+}
+
+func (s *UserService) createUser(ctx context.Context, user *models.User) error {
 	if !isValidEmail(user.Email) {
-		return errors.New("invalid email format")
+		return ErrInvalidEmail
 	}
 
 	if len(user.Password) < 8 {
-		return errors.New("password must be at least 8 characters")
+		return ErrWeakPassword
 	}
 
-	existing, err := s.UserRepo.GetUserByEmail(user.Email)
+	existing, err := s.UserRepo.GetUserByEmail(ctx, user.Email)
 	if err == nil && existing != nil {
 		return ErrEmailExists
 	}
 
-	hashedPass, err := utils.HashPassword(user.Password) 
+	hashedPass, err := utils.HashPassword(user.Password)
 	if err != nil {
 		return err
 	}
 	user.Password = hashedPass
 
-	err = s.UserRepo.CreateUser(user)
+	// the role is never taken from the request; admins are seeded or promoted
+	user.Role = models.RoleCustomer
+	user.DisabledAt = nil
+	user.EmailVerifiedAt = nil
+
+	err = s.UserRepo.CreateUser(ctx, user)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// BootstrapAdmin creates the first admin account. It does nothing once an
+// active admin exists, so it is safe to run on every start.
+func (s *UserService) BootstrapAdmin(ctx context.Context, name, email, password string) (bool, error) {
+	admins, err := s.UserRepo.CountActiveUsersByRole(ctx, models.RoleAdmin)
+	if err != nil {
+		return false, err
+	}
+	if admins > 0 {
+		return false, nil
+	}
+
+	existing, err := s.UserRepo.GetUserByEmail(ctx, email)
+	if err == nil && existing != nil {
+		// promoting a self-registered account would hand admin rights to
+		// whoever registered the address first
+		return false, ErrBootstrapEmailUsed
+	}
+
+	admin := &models.User{Name: name, Email: email, Password: password}
+	err = s.UserRepo.Transaction(ctx, func(ctx context.Context) error {
+		err := s.createUser(ctx, admin)
+		if err != nil {
+			return err
+		}
+		// the operator chose this address, so there is nothing to verify
+		err = s.UserRepo.MarkEmailVerified(ctx, admin.ID)
+		if err != nil {
+			return err
+		}
+		return s.UserRepo.UpdateUserRole(ctx, admin.ID, models.RoleAdmin)
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Login answers ErrInvalidCredentials for both unknown emails and wrong
+// passwords, and is throttled by Guard. Users with MFA enabled get a challenge
+// instead of tokens.
+func (s *UserService) Login(ctx context.Context, login *models.User, userAgent, ip string) (*LoginResult, error) {
+	err := s.Guard.Check(ctx, login.Email, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.UserRepo.GetUserByEmail(ctx, login.Email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if user == nil {
+		// compare anyway so response times do not reveal which emails exist
+		utils.ComparePassword(dummyPasswordHash(), login.Password)
+	}
+	if user == nil || utils.ComparePassword(user.Password, login.Password) != nil {
+		err = s.Guard.Failure(ctx, login.Email, ip)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	if user.DisabledAt != nil {
+		return nil, ErrAccountDisabled
+	}
+
+	mfaEnabled, err := s.MFA.IsEnabled(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if mfaEnabled {
+		// the account counter is only reset once the second factor passes
+		challenge, err := s.createMFAChallenge(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{Challenge: challenge}, nil
+	}
+
+	err = s.Guard.Success(ctx, login.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.Sessions.StartSession(ctx, user, userAgent, ip, false)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{Tokens: tokens}, nil
+}
+
+func (s *UserService) createMFAChallenge(ctx context.Context, userID uuid.UUID) (*MFAChallenge, error) {
+	token, err := utils.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.UserRepo.CreateUserToken(ctx, &models.UserToken{
+		UserID:    userID,
+		Purpose:   models.TokenPurposeMFAChallenge,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(mfaChallengeTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MFAChallenge{
+		MFARequired:    true,
+		ChallengeToken: token,
+		ExpiresIn:      int64(mfaChallengeTTL.Seconds()),
+	}, nil
+}
+
+// CompleteMFALogin finishes a login started by Login. Wrong codes count as
+// failed logins; the challenge stays usable until it expires or succeeds.
+func (s *UserService) CompleteMFALogin(ctx context.Context, challenge, code, userAgent, ip string) (*TokenPair, error) {
+	record, err := s.UserRepo.GetUserTokenByHash(ctx, models.TokenPurposeMFAChallenge, utils.HashToken(challenge))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidMFAChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+	if record.UsedAt != nil || record.ExpiresAt.Before(time.Now()) {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	user, err := s.UserRepo.GetUserByID(ctx, record.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Guard.Check(ctx, user.Email, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.MFA.VerifyCode(ctx, user.ID, code)
+	if errors.Is(err, ErrInvalidMFACode) {
+		err = s.Guard.Failure(ctx, user.Email, ip)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidMFACode
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fresh, err := s.UserRepo.MarkUserTokenUsed(ctx, record.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	err = s.Guard.Success(ctx, user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.DisabledAt != nil {
+		return nil, ErrAccountDisabled
+	}
+
+	return s.Sessions.StartSession(ctx, user, userAgent, ip, true)
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = utils.HashPassword("not-a-real-password")
+	})
+	return dummyHash
+}
+
+func (s *UserService) Logout(ctx context.Context, token string, userID, sessionID uuid.UUID) error {
+	err := s.UserRepo.AddTokenToBlacklist(ctx, token)
+	if err != nil {
+		return err
+	}
+	return s.Sessions.RevokeSession(ctx, userID, sessionID)
+}
+
+func (s *UserService) AddTokenToBlacklist(ctx context.Context, token string) error {
+	err := s.UserRepo.AddTokenToBlacklist(ctx, token)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ForgotPassword emails a reset link. Unknown and disabled accounts are
+// silently ignored so the endpoint cannot be used to discover addresses.
+func (s *UserService) ForgotPassword(ctx context.Context, email string) error {
+	user, err := s.UserRepo.GetUserByEmail(ctx, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.DisabledAt != nil {
+		return nil
+	}
+
+	token, err := utils.GenerateToken()
+	if err != nil {
+		return err
+	}
+
+	err = s.UserRepo.Transaction(ctx, func(ctx context.Context) error {
+		// only the most recent link works
+		err := s.UserRepo.InvalidateUserTokens(ctx, user.ID, models.TokenPurposePasswordReset)
+		if err != nil {
+			return err
+		}
+		return s.UserRepo.CreateUserToken(ctx, &models.UserToken{
+			UserID:    user.ID,
+			Purpose:   models.TokenPurposePasswordReset,
+			TokenHash: utils.HashToken(token),
+			ExpiresAt: time.Now().Add(s.PasswordResetTTL),
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", s.AppURL, url.QueryEscape(token))
+	return s.Mail.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires in %s and can only be used once.\n\n%s\n\n"+
+			"If you did not ask for this, you can ignore this email.", user.Name, s.PasswordResetTTL, link),
+	})
+}
+
+// ResetPassword redeems a reset token and logs the user out everywhere.
+func (s *UserService) ResetPassword(ctx context.Context, token, password string) error {
+	if len(password) < 8 {
+		return ErrWeakPassword
+	}
+
+	hashedPass, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return s.UserRepo.Transaction(ctx, func(ctx context.Context) error {
+		record, err := s.UserRepo.GetUserTokenByHash(ctx, models.TokenPurposePasswordReset, utils.HashToken(token))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+
+		fresh, err := s.UserRepo.MarkUserTokenUsed(ctx, record.ID)
+		if err != nil {
+			return err
+		}
+		if !fresh || record.ExpiresAt.Before(time.Now()) {
+			return ErrInvalidResetToken
+		}
+
+		err = s.UserRepo.UpdatePassword(ctx, record.UserID, hashedPass)
+		if err != nil {
+			return err
+		}
+
+		// following the emailed link proves the address belongs to the user
+		err = s.UserRepo.MarkEmailVerified(ctx, record.UserID)
+		if err != nil {
+			return err
+		}
+
+		// access tokens die with their session, so this also ends them
+		return s.Sessions.RevokeAllSessions(ctx, record.UserID)
+	})
+}
+
+// ResendVerification emails a new verification link, invalidating older ones.
+func (s *UserService) ResendVerification(ctx context.Context, userID uuid.UUID) error {
+	user, err := s.UserRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return ErrAlreadyVerified
+	}
+
+	now := time.Now()
+	recent, err := s.UserRepo.CountUserTokensSince(ctx, userID, models.TokenPurposeEmailVerification, now.Add(-resendInterval))
+	if err != nil {
+		return err
+	}
+	hourly, err := s.UserRepo.CountUserTokensSince(ctx, userID, models.TokenPurposeEmailVerification, now.Add(-time.Hour))
+	if err != nil {
+		return err
+	}
+	if recent > 0 || hourly >= resendHourlyLimit {
+		return ErrResendThrottled
+	}
+
+	return s.sendVerificationEmail(ctx, user)
+}
+
+func (s *UserService) sendVerificationEmail(ctx context.Context, user *models.User) error {
+	token, err := utils.GenerateToken()
+	if err != nil {
+		return err
+	}
+
+	err = s.UserRepo.Transaction(ctx, func(ctx context.Context) error {
+		err := s.UserRepo.InvalidateUserTokens(ctx, user.ID, models.TokenPurposeEmailVerification)
+		if err != nil {
+			return err
+		}
+		return s.UserRepo.CreateUserToken(ctx, &models.UserToken{
+			UserID:    user.ID,
+			Purpose:   models.TokenPurposeEmailVerification,
+			TokenHash: utils.HashToken(token),
+			ExpiresAt: time.Now().Add(s.VerificationTTL),
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/api/v1/verify-email?token=%s", s.AppURL, url.QueryEscape(token))
+	return s.Mail.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below. It expires in %s.\n\n%s",
+			user.Name, s.VerificationTTL, link),
+	})
+}
+
+func (s *UserService) VerifyEmail(ctx context.Context, token string) error {
+	return s.UserRepo.Transaction(ctx, func(ctx context.Context) error {
+		record, err := s.UserRepo.GetUserTokenByHash(ctx, models.TokenPurposeEmailVerification, utils.HashToken(token))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidVerifyToken
+		}
+		if err != nil {
+			return err
+		}
+
+		fresh, err := s.UserRepo.MarkUserTokenUsed(ctx, record.ID)
+		if err != nil {
+			return err
+		}
+		if !fresh || record.ExpiresAt.Before(time.Now()) {
+			return ErrInvalidVerifyToken
+		}
+
+		return s.UserRepo.MarkEmailVerified(ctx, record.UserID)
+	})
+}
+
+type Profile struct {
+	User       *models.User
+	MFAEnabled bool
+}
+
+func (s *UserService) GetProfile(ctx context.Context, userID uuid.UUID) (*Profile, error) {
+	user, err := s.UserRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	mfaEnabled, err := s.MFA.IsEnabled(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &Profile{User: user, MFAEnabled: mfaEnabled}, nil
+}
+
+func (s *UserService) UpdateName(ctx context.Context, userID uuid.UUID, name string) (*Profile, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return nil, ErrInvalidName
+	}
+
+	err := s.UserRepo.UpdateUserName(ctx, userID, name)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetProfile(ctx, userID)
+}
+
+// reauthenticate guards sensitive changes: the current password, plus a code
+// when MFA is on. Failures count towards the login lockout.
+func (s *UserService) reauthenticate(ctx context.Context, user *models.User, password, code, ip string) error {
+	err := s.Guard.Check(ctx, user.Email, ip)
+	if err != nil {
+		return err
+	}
+
+	if utils.ComparePassword(user.Password, password) != nil {
+		err = s.Guard.Failure(ctx, user.Email, ip)
+		if err != nil {
+			return err
+		}
+		return ErrReauthFailed
+	}
+
+	mfaEnabled, err := s.MFA.IsEnabled(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if !mfaEnabled {
+		return nil
+	}
+	if code == "" {
+		return ErrMFACodeRequired
+	}
+
+	err = s.MFA.VerifyCode(ctx, user.ID, code)
+	if errors.Is(err, ErrInvalidMFACode) {
+		err = s.Guard.Failure(ctx, user.Email, ip)
+		if err != nil {
+			return err
+		}
+		return ErrReauthFailed
+	}
+	return err
+}
+
+// RequestEmailChange emails a confirmation link to the new address. The
+// address only changes once that link is opened.
+func (s *UserService) RequestEmailChange(ctx context.Context, userID uuid.UUID, email, password, code, ip string) error {
+	email = strings.TrimSpace(email)
+	if !isValidEmail(email) {
+		return ErrInvalidEmail
+	}
+
+	user, err := s.UserRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(user.Email, email) {
+		return ErrEmailUnchanged
+	}
+
+	err = s.reauthenticate(ctx, user, password, code, ip)
+	if err != nil {
+		return err
+	}
+
+	existing, err := s.UserRepo.GetUserByEmail(ctx, email)
+	if err == nil && existing != nil {
+		return ErrEmailExists
+	}
+
+	token, err := utils.GenerateToken()
 	if err != nil {
 		return err
 	}
+
+	err = s.UserRepo.Transaction(ctx, func(ctx context.Context) error {
+		err := s.UserRepo.InvalidateUserTokens(ctx, userID, models.TokenPurposeEmailChange)
+		if err != nil {
+			return err
+		}
+		return s.UserRepo.CreateUserToken(ctx, &models.UserToken{
+			UserID:    userID,
+			Purpose:   models.TokenPurposeEmailChange,
+			TokenHash: utils.HashToken(token),
+			Payload:   email,
+			ExpiresAt: time.Now().Add(s.VerificationTTL),
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/api/v1/me/email/confirm?token=%s", s.AppURL, url.QueryEscape(token))
+	return s.Mail.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the link below to use this address for your account. It expires in %s.\n\n%s",
+			user.Name, s.VerificationTTL, link),
+	})
+}
+
+func (s *UserService) ConfirmEmailChange(ctx context.Context, token string) error {
+	var user *models.User
+	var email string
+	err := s.UserRepo.Transaction(ctx, func(ctx context.Context) error {
+		record, err := s.UserRepo.GetUserTokenByHash(ctx, models.TokenPurposeEmailChange, utils.HashToken(token))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidEmailToken
+		}
+		if err != nil {
+			return err
+		}
+
+		fresh, err := s.UserRepo.MarkUserTokenUsed(ctx, record.ID)
+		if err != nil {
+			return err
+		}
+		if !fresh || record.ExpiresAt.Before(time.Now()) {
+			return ErrInvalidEmailToken
+		}
+
+		// the address may have been registered since the link was sent
+		existing, err := s.UserRepo.GetUserByEmail(ctx, record.Payload)
+		if err == nil && existing != nil {
+			return ErrEmailExists
+		}
+
+		user, err = s.UserRepo.GetUserByID(ctx, record.UserID)
+		if err != nil {
+			return err
+		}
+		email = record.Payload
+		return s.UserRepo.UpdateUserEmail(ctx, record.UserID, email)
+	})
+	if err != nil {
+		return err
+	}
+
+	// tell the old address, in case the change was not made by its owner
+	err = s.Mail.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe email address of your account was changed to %s. "+
+			"If you did not do this, please contact support immediately.", user.Name, email),
+	})
+	if err != nil {
+		log.Printf("sending email change notice to user %s: %v", user.ID, err)
+	}
 	return nil
 }
 
-func (s *UserService) Login(login *models.User) (string, error) {
-	user, err := s.UserRepo.GetUserByEmail(login.Email)
+// ChangePassword keeps the current session and logs out every other one.
+func (s *UserService) ChangePassword(ctx context.Context, userID, sessionID uuid.UUID, current, password, code, ip string) error {
+	if len(password) < 8 {
+		return ErrWeakPassword
+	}
+
+	user, err := s.UserRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	err = s.reauthenticate(ctx, user, current, code, ip)
+	if err != nil {
+		return err
+	}
+
+	hashedPass, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return s.UserRepo.Transaction(ctx, func(ctx context.Context) error {
+		err := s.UserRepo.UpdatePassword(ctx, userID, hashedPass)
+		if err != nil {
+			return err
+		}
+		err = s.UserRepo.InvalidateUserTokens(ctx, userID, models.TokenPurposePasswordReset)
+		if err != nil {
+			return err
+		}
+		return s.Sessions.RevokeOtherSessions(ctx, userID, sessionID)
+	})
+}
+
+// DeleteAccount anonymises the user and logs them out everywhere. Orders are
+// kept for bookkeeping.
+func (s *UserService) DeleteAccount(ctx context.Context, userID uuid.UUID, password, code, ip string) error {
+	user, err := s.UserRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	err = s.reauthenticate(ctx, user, password, code, ip)
+	if err != nil {
+		return err
+	}
+
+	if user.Role == models.RoleAdmin {
+		err = s.ensureAnotherAdmin(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.UserRepo.Transaction(ctx, func(ctx context.Context) error {
+		err := s.MFA.MFARepo.DeleteMFA(ctx, userID)
+		if err != nil {
+			return err
+		}
+		err = s.UserRepo.AnonymizeUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		return s.Sessions.RevokeAllSessions(ctx, userID)
+	})
+	if err != nil {
+		return err
+	}
+
+	// the lockout counter is keyed by the old address
+	return s.Guard.Unlock(ctx, user.Email)
+}
+
+func (s *UserService) ListUsers(ctx context.Context, page, limit int) ([]models.User, int64, error) {
+	return s.UserRepo.ListUsers(ctx, page, limit)
+}
+
+func (s *UserService) ChangeRole(ctx context.Context, actorRole string, userID uuid.UUID, role string) (*models.User, error) {
+	_, err := s.RoleRepo.GetRole(ctx, role)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidRole
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.UserRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.ensureCanManage(ctx, actorRole, user.Role, role)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role == role {
+		return user, nil
+	}
+
+	if user.Role == models.RoleAdmin && user.DisabledAt == nil {
+		err = s.ensureAnotherAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.UserRepo.UpdateUserRole(ctx, userID, role)
+	if err != nil {
+		return nil, err
+	}
+	user.Role = role
+	return user, nil
+}
+
+// DisableUser blocks the account and revokes its sessions. Access tokens that
+// are still valid are rejected by the auth middleware.
+func (s *UserService) DisableUser(ctx context.Context, actorID uuid.UUID, actorRole string, userID uuid.UUID) (*models.User, error) {
+	if actorID == userID {
+		return nil, ErrCannotDisableSelf
+	}
+
+	user, err := s.UserRepo.GetUserByID(ctx, userID)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	err = utils.ComparePassword(user.Password, login.Password)
+	err = s.ensureCanManage(ctx, actorRole, user.Role)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if user.DisabledAt != nil {
+		return user, nil
 	}
 
-	var secret = os.Getenv("JWT_SECRET")
+	if user.Role == models.RoleAdmin {
+		err = s.ensureAnotherAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	token, err := middleware.GenerateJWT(secret, user.ID, user.Role)
+	now := time.Now()
+	err = s.UserRepo.SetUserDisabled(ctx, userID, &now)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return token, nil
+	user.DisabledAt = &now
+
+	err = s.Sessions.RevokeAllSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
-func (s *UserService) AddTokenToBlacklist(token string) error {
-	err := s.UserRepo.AddTokenToBlacklist(token)
+func (s *UserService) EnableUser(ctx context.Context, actorRole string, userID uuid.UUID) (*models.User, error) {
+	user, err := s.UserRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.ensureCanManage(ctx, actorRole, user.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.UserRepo.SetUserDisabled(ctx, userID, nil)
+	if err != nil {
+		return nil, err
+	}
+	user.DisabledAt = nil
+	return user, nil
+}
+
+// UnlockUser clears the failed-login counter of the user's account.
+func (s *UserService) UnlockUser(ctx context.Context, actorRole string, userID uuid.UUID) (*models.User, error) {
+	user, err := s.UserRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.ensureCanManage(ctx, actorRole, user.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Guard.Unlock(ctx, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *UserService) ListRoles(ctx context.Context) ([]models.Role, error) {
+	return s.RoleRepo.ListRoles(ctx)
+}
+
+var roleNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,50}$`)
+
+// SaveRole creates a role or replaces the permissions of an existing one. The
+// admin role is fixed so that nobody can lock everyone out of user management,
+// and the customer role because every self-registered account gets it: any
+// permission added there would be handed to whoever signs up.
+func (s *UserService) SaveRole(ctx context.Context, actorRole, name, description string, permissions []string) (*models.Role, error) {
+	if !roleNamePattern.MatchString(name) {
+		return nil, ErrInvalidRoleName
+	}
+	if name == models.RoleAdmin || name == models.RoleCustomer {
+		return nil, ErrBuiltInRole
+	}
+
+	seen := map[string]bool{}
+	var unique []string
+	for _, p := range permissions {
+		if !models.IsKnownPermission(p) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownPermission, p)
+		}
+		if !seen[p] {
+			seen[p] = true
+			unique = append(unique, p)
+		}
+	}
+
+	err := s.ensureCanGrant(ctx, actorRole, unique)
+	if err == nil {
+		err = s.ensureCanManage(ctx, actorRole, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	role := &models.Role{Name: name, Description: description}
+	err = s.RoleRepo.SaveRole(ctx, role, unique)
+	if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (s *UserService) ensureAnotherAdmin(ctx context.Context) error {
+	admins, err := s.UserRepo.CountActiveUsersByRole(ctx, models.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if admins <= 1 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// ensureCanManage stops users:manage holders from escalating: the actor's role
+// must already hold every permission of the roles involved.
+func (s *UserService) ensureCanManage(ctx context.Context, actorRole string, roles ...string) error {
+	for _, role := range roles {
+		permissions, err := s.RoleRepo.GetPermissions(ctx, role)
+		if err != nil {
+			return err
+		}
+		err = s.ensureCanGrant(ctx, actorRole, permissions)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *UserService) ensureCanGrant(ctx context.Context, actorRole string, permissions []string) error {
+	held, err := s.RoleRepo.GetPermissions(ctx, actorRole)
 	if err != nil {
 		return err
 	}
+
+	granted := map[string]bool{}
+	for _, p := range held {
+		granted[p] = true
+	}
+	for _, p := range permissions {
+		if !granted[p] {
+			return ErrPrivilegeEscalation
+		}
+	}
 	return nil
 }
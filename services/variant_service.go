@@ -0,0 +1,346 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"sort"
+	"strings"
+	"vigilant-spork/models"
+	"vigilant-spork/repository"
+)
+
+type VariantService struct {
+	VariantRepo repository.VariantRepository
+	ProductRepo repository.ProductRepository
+}
+
+var (
+	ErrProductNotFound  = errors.New("product not found")
+	ErrVariantNotFound  = errors.New("variant not found")
+	ErrVariantRequired  = errors.New("product has several variants; variant_id is required")
+	ErrOptionNotFound   = errors.New("option not found")
+	ErrInvalidOption    = errors.New("option name must be between 1 and 50 characters")
+	ErrOptionTaken      = errors.New("product already has an option with that name")
+	ErrUnknownOption    = errors.New("variant refers to an option the product does not have")
+	ErrInvalidVariant   = errors.New("variant price must be positive and stock cannot be negative")
+	ErrInvalidSKU       = errors.New("sku must be between 1 and 64 characters")
+	ErrSKUTaken         = errors.New("another variant already uses that sku")
+	ErrDuplicateVariant = errors.New("product already has a variant with those option values")
+	ErrLastVariant      = errors.New("a product must keep at least one variant")
+)
+
+// VariantRequest creates a variant. Options maps option names to values;
+// options left out stay unset. An empty SKU is generated.
+type VariantRequest struct {
+	SKU           string            `json:"sku"`
+	Price         *int64            `json:"price"`
+	StockQuantity int               `json:"stock_quantity"`
+	Options       map[string]string `json:"options"`
+}
+
+// VariantUpdate changes a variant. Nil fields are left as they are;
+// UseProductPrice drops the price override.
+type VariantUpdate struct {
+	SKU             *string `json:"sku"`
+	Price           *int64  `json:"price"`
+	UseProductPrice bool    `json:"use_product_price"`
+	StockQuantity   *int    `json:"stock_quantity"`
+}
+
+// GetProductVariants returns the product with its options and variants.
+func (s *VariantService) GetProductVariants(ctx context.Context, productID uuid.UUID) (*models.Product, error) {
+	product, err := s.ProductRepo.GetProductByID(ctx, productID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return product, nil
+}
+
+// AddOption adds an option after the product's existing ones. Existing
+// variants have no value for it until they are replaced.
+func (s *VariantService) AddOption(ctx context.Context, productID uuid.UUID, name string) (*models.ProductOption, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > 50 {
+		return nil, ErrInvalidOption
+	}
+
+	option := &models.ProductOption{ProductID: productID, Name: name}
+	err := s.VariantRepo.Transaction(ctx, func(ctx context.Context) error {
+		err := s.lockProduct(ctx, productID)
+		if err != nil {
+			return err
+		}
+
+		options, err := s.VariantRepo.ListOptions(ctx, productID)
+		if err != nil {
+			return err
+		}
+		for _, o := range options {
+			if strings.EqualFold(o.Name, name) {
+				return ErrOptionTaken
+			}
+			if o.Position >= option.Position {
+				option.Position = o.Position + 1
+			}
+		}
+
+		return s.VariantRepo.CreateOption(ctx, option)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return option, nil
+}
+
+// DeleteOption removes an option and every variant's value for it. It is
+// refused if two variants would then have the same values.
+func (s *VariantService) DeleteOption(ctx context.Context, productID, optionID uuid.UUID) error {
+	return s.VariantRepo.Transaction(ctx, func(ctx context.Context) error {
+		err := s.lockProduct(ctx, productID)
+		if err != nil {
+			return err
+		}
+
+		variants, err := s.VariantRepo.ListVariants(ctx, productID)
+		if err != nil {
+			return err
+		}
+
+		seen := map[string]bool{}
+		for _, v := range variants {
+			key := optionKey(v.Options, optionID)
+			if seen[key] {
+				return ErrDuplicateVariant
+			}
+			seen[key] = true
+		}
+
+		err = s.VariantRepo.DeleteOption(ctx, productID, optionID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrOptionNotFound
+		}
+		return err
+	})
+}
+
+// CreateVariant adds a variant to the product and adds its stock to the
+// product's.
+func (s *VariantService) CreateVariant(ctx context.Context, productID uuid.UUID, req VariantRequest) (*models.Variant, error) {
+	if (req.Price != nil && *req.Price <= 0) || req.StockQuantity < 0 {
+		return nil, ErrInvalidVariant
+	}
+
+	variant := &models.Variant{
+		ProductID:     productID,
+		SKU:           strings.TrimSpace(req.SKU),
+		Price:         req.Price,
+		StockQuantity: req.StockQuantity,
+	}
+	if variant.SKU == "" {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return nil, err
+		}
+		variant.SKU = models.DefaultSKU(id)
+	}
+	if len(variant.SKU) > 64 {
+		return nil, ErrInvalidSKU
+	}
+
+	err := s.VariantRepo.Transaction(ctx, func(ctx context.Context) error {
+		err := s.lockProduct(ctx, productID)
+		if err != nil {
+			return err
+		}
+
+		options, err := s.VariantRepo.ListOptions(ctx, productID)
+		if err != nil {
+			return err
+		}
+		byName := map[string]uuid.UUID{}
+		for _, o := range options {
+			byName[strings.ToLower(o.Name)] = o.ID
+		}
+		for name, value := range req.Options {
+			optionID, ok := byName[strings.ToLower(strings.TrimSpace(name))]
+			value = strings.TrimSpace(value)
+			if !ok || value == "" {
+				return ErrUnknownOption
+			}
+			variant.Options = append(variant.Options, models.VariantOption{OptionID: optionID, Value: value})
+		}
+
+		variants, err := s.VariantRepo.ListVariants(ctx, productID)
+		if err != nil {
+			return err
+		}
+		key := optionKey(variant.Options, uuid.Nil)
+		for _, v := range variants {
+			if optionKey(v.Options, uuid.Nil) == key {
+				return ErrDuplicateVariant
+			}
+		}
+
+		err = s.ensureSKUFree(ctx, variant.SKU, uuid.Nil)
+		if err != nil {
+			return err
+		}
+
+		err = s.VariantRepo.CreateVariant(ctx, variant)
+		if err != nil {
+			return err
+		}
+		return s.VariantRepo.SyncProduct(ctx, productID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return variant, nil
+}
+
+func (s *VariantService) UpdateVariant(ctx context.Context, productID, variantID uuid.UUID, req VariantUpdate) (*models.Variant, error) {
+	if (req.Price != nil && *req.Price <= 0) || (req.StockQuantity != nil && *req.StockQuantity < 0) {
+		return nil, ErrInvalidVariant
+	}
+
+	var variant *models.Variant
+	err := s.VariantRepo.Transaction(ctx, func(ctx context.Context) error {
+		var err error
+		variant, err = s.VariantRepo.LockVariant(ctx, productID, variantID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrVariantNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if req.SKU != nil {
+			sku := strings.TrimSpace(*req.SKU)
+			if sku == "" || len(sku) > 64 {
+				return ErrInvalidSKU
+			}
+			err = s.ensureSKUFree(ctx, sku, variant.ID)
+			if err != nil {
+				return err
+			}
+			variant.SKU = sku
+		}
+		if req.UseProductPrice {
+			variant.Price = nil
+		} else if req.Price != nil {
+			variant.Price = req.Price
+		}
+		if req.StockQuantity != nil {
+			variant.StockQuantity = *req.StockQuantity
+		}
+
+		err = s.VariantRepo.UpdateVariant(ctx, variant, req.StockQuantity != nil)
+		if err != nil {
+			return err
+		}
+		return s.VariantRepo.SyncProduct(ctx, productID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return variant, nil
+}
+
+// DeleteVariant removes a variant, taking it out of any carts holding it.
+func (s *VariantService) DeleteVariant(ctx context.Context, productID, variantID uuid.UUID) error {
+	return s.VariantRepo.Transaction(ctx, func(ctx context.Context) error {
+		err := s.lockProduct(ctx, productID)
+		if err != nil {
+			return err
+		}
+
+		variants, err := s.VariantRepo.ListVariants(ctx, productID)
+		if err != nil {
+			return err
+		}
+		if len(variants) == 1 && variants[0].ID == variantID {
+			return ErrLastVariant
+		}
+
+		err = s.VariantRepo.DeleteVariant(ctx, productID, variantID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrVariantNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return s.VariantRepo.SyncProduct(ctx, productID)
+	})
+}
+
+// resolveVariant finds the variant of a product a shopper means. Without an
+// ID only a product with a single variant can be resolved.
+func resolveVariant(ctx context.Context, repo repository.VariantRepository, productID uuid.UUID,
+	variantID *uuid.UUID) (*models.Variant, error) {
+	if variantID != nil {
+		variant, err := repo.GetVariant(ctx, productID, *variantID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrVariantNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		return variant, nil
+	}
+
+	variants, err := repo.ListVariants(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	switch len(variants) {
+	case 0:
+		return nil, ErrProductNotFound
+	case 1:
+		return &variants[0], nil
+	default:
+		return nil, ErrVariantRequired
+	}
+}
+
+// lockProduct serialises changes to one product's options and variants; see
+// VariantRepository.LockProduct.
+func (s *VariantService) lockProduct(ctx context.Context, productID uuid.UUID) error {
+	err := s.VariantRepo.LockProduct(ctx, productID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrProductNotFound
+	}
+	return err
+}
+
+func (s *VariantService) ensureSKUFree(ctx context.Context, sku string, variantID uuid.UUID) error {
+	existing, err := s.VariantRepo.GetVariantBySKU(ctx, sku)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing.ID != variantID {
+		return ErrSKUTaken
+	}
+	return nil
+}
+
+// optionKey identifies a variant by its option values, ignoring the option
+// skip, so that variants with the same values compare equal.
+func optionKey(values []models.VariantOption, skip uuid.UUID) string {
+	var parts []string
+	for _, v := range values {
+		if v.OptionID == skip {
+			continue
+		}
+		parts = append(parts, v.OptionID.String()+"="+strings.ToLower(v.Value))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ";")
+}
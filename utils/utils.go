@@ -1,9 +1,16 @@
 package utils
 
 import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"golang.org/x/crypto/bcrypt"
+	"net"
 	"net/http"
+	"strings"
 )
 
 func HashPassword(password string) (string, error) {
@@ -31,3 +38,58 @@ func WriteJSON(w http.ResponseWriter, status int, data interface{}) {
 func ErrorJSON(w http.ResponseWriter, status int, msg string) {
 	WriteJSON(w, status, map[string]string{"error": msg})
 }
+
+// GenerateToken returns a random URL-safe token suitable for refresh, reset
+// and verification links. Only its HashToken digest should be stored.
+func GenerateToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ClientIP returns the address of the peer that made the request.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Slugify lower-cases s and joins its ASCII letters and digits with hyphens,
+// so "Men's T-Shirts" becomes "men-s-t-shirts". A name without any, such as
+// "Книги", gets "category-" and the first eight hex digits of the MD5 of the
+// space-trimmed name instead, so distinct names keep distinct slugs.
+// Migration 0013 uses the same rule in SQL.
+func Slugify(s string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+			continue
+		}
+		hyphen = true
+	}
+	if b.Len() == 0 {
+		trimmed := strings.Trim(s, " ")
+		if trimmed == "" {
+			return ""
+		}
+		sum := md5.Sum([]byte(trimmed))
+		return "category-" + hex.EncodeToString(sum[:4])
+	}
+	return b.String()
+}